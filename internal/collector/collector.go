@@ -0,0 +1,278 @@
+package collector
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	configmetrics "github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/config"
+	"github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/resourceid"
+	"github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/singleflight"
+	"google.golang.org/protobuf/proto"
+)
+
+// fixedLabels are the labels every series has, besides the metric dimensions
+var fixedLabels = []string{
+	"ResourceId", "metricName", "aggregation", "unit",
+	"subscription_id", "resource_group", "resource_provider", "resource_type", "resource_name",
+}
+
+type point struct {
+	timestamp time.Time
+	value     float64
+}
+type Options struct {
+	// FullWindow exports every datapoint of the timeseries window instead of only the latest one
+	FullWindow bool
+	// StaleSeriesGracePeriod is how long a series missing from the Azure response is still exported
+	StaleSeriesGracePeriod time.Duration
+}
+
+type series struct {
+	name     string
+	labels   prometheus.Labels
+	points   []point
+	lastSeen time.Time
+}
+
+// Collector holds the Azure Monitor datapoints and exports them as gauges, using the datapoint
+// time as the sample timestamp. It is not a prometheus.Collector: Gatherer is its only export path.
+type Collector struct {
+	mu         sync.RWMutex
+	series     map[string]*series
+	fullWindow bool
+
+	scrape      func(ctx context.Context) error
+	minInterval time.Duration
+	lastScrape  time.Time
+	group       singleflight.Group
+}
+
+func New() *Collector {
+	return &Collector{series: map[string]*series{}}
+}
+
+// Update replaces the datapoints of every series found in the samples and removes
+// the series that have not been seen for longer than the grace period.
+func (c *Collector) Update(samples []configmetrics.Sample, opts Options) {
+	now := time.Now()
+
+	received := map[string]*series{}
+	for _, sample := range samples {
+		name, labels := SeriesIdentity(sample)
+		key := seriesKey(name, labels)
+
+		s, ok := received[key]
+		if !ok {
+			s = &series{name: name, labels: labels, lastSeen: now}
+			received[key] = s
+		}
+		s.points = append(s.points, point{timestamp: sample.Timestamp, value: sample.Value})
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.fullWindow = opts.FullWindow
+	for key, s := range received {
+		sort.SliceStable(s.points, func(i, j int) bool { return s.points[i].timestamp.Before(s.points[j].timestamp) })
+		c.series[key] = s
+	}
+
+	for key, s := range c.series {
+		if now.Sub(s.lastSeen) > opts.StaleSeriesGracePeriod {
+			delete(c.series, key)
+		}
+	}
+}
+
+// OnDemand makes every Refresh first refresh the series by calling scrape, unless the last
+// call is more recent than minInterval. Concurrent refreshes share the same call. A nil
+// scrape disables the on-demand mode.
+func (c *Collector) OnDemand(scrape func(ctx context.Context) error, minInterval time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scrape, c.minInterval = scrape, minInterval
+}
+
+// Refresh calls the on-demand scrape, if any and if the series are older than the minimum
+// interval. The context bounds the scrape, e.g. to the Prometheus scrape timeout.
+func (c *Collector) Refresh(ctx context.Context) {
+	c.mu.RLock()
+	scrape, fresh := c.scrape, time.Since(c.lastScrape) < c.minInterval
+	c.mu.RUnlock()
+	if scrape == nil || fresh {
+		return
+	}
+
+	c.group.Do("scrape", func() (any, error) {
+		c.mu.RLock()
+		fresh := time.Since(c.lastScrape) < c.minInterval
+		c.mu.RUnlock()
+		if fresh {
+			return nil, nil
+		}
+
+		if err := scrape(ctx); err != nil {
+			log.Logger.Error().Err(err).Msg("error while scraping on demand")
+		}
+
+		// A failed scrape is not retried before the minimum interval either
+		c.mu.Lock()
+		c.lastScrape = time.Now()
+		c.mu.Unlock()
+		return nil, nil
+	})
+}
+
+// Len returns the number of series currently exported.
+func (c *Collector) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.series)
+}
+
+// Gatherer returns a prometheus.Gatherer serving the metrics of g followed by the
+// collector's, and is the only way the collector's series are exported. A Registry
+// rejects repeated samples of the same series, hence the full timeseries window
+// cannot be served by a registered prometheus.Collector; the latest-only mode goes
+// through the same path so that switching mode on reload needs no re-registration.
+func (c *Collector) Gatherer(g prometheus.Gatherer) prometheus.Gatherer {
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		mfs, err := g.Gather()
+
+		families := map[string]*dto.MetricFamily{}
+		for _, mf := range mfs {
+			families[mf.GetName()] = mf
+		}
+
+		c.each(func(s *series, p point) {
+			mf, ok := families[s.name]
+			if !ok {
+				mf = &dto.MetricFamily{
+					Name: proto.String(s.name),
+					Help: proto.String(""),
+					Type: dto.MetricType_GAUGE.Enum(),
+				}
+				families[s.name] = mf
+				mfs = append(mfs, mf)
+			}
+			if mf.GetType() != dto.MetricType_GAUGE {
+				return
+			}
+
+			m := &dto.Metric{
+				Gauge:       &dto.Gauge{Value: proto.Float64(p.value)},
+				TimestampMs: proto.Int64(p.timestamp.UnixMilli()),
+			}
+			for _, name := range sortedKeys(s.labels) {
+				m.Label = append(m.Label, &dto.LabelPair{
+					Name:  proto.String(name),
+					Value: proto.String(s.labels[name]),
+				})
+			}
+			mf.Metric = append(mf.Metric, m)
+		})
+
+		sort.Slice(mfs, func(i, j int) bool { return mfs[i].GetName() < mfs[j].GetName() })
+		return mfs, err
+	})
+}
+
+// each calls fn for every exported datapoint: the latest of each series,
+// or all of them when the full window is exported.
+func (c *Collector) each(fn func(s *series, p point)) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, s := range c.series {
+		if len(s.points) == 0 {
+			continue
+		}
+		if !c.fullWindow {
+			fn(s, s.points[len(s.points)-1])
+			continue
+		}
+		for _, p := range s.points {
+			fn(s, p)
+		}
+	}
+}
+
+// SeriesIdentity returns the metric name and the labels of the series a sample belongs to:
+// the fixed labels plus one sanitized label per dimension. The timestamp and the value
+// are not part of the series identity.
+func SeriesIdentity(sample configmetrics.Sample) (string, prometheus.Labels) {
+	return utils.SanitizeLabelName(sample.Metric), seriesLabels(sample)
+}
+
+func seriesLabels(sample configmetrics.Sample) prometheus.Labels {
+	labels := prometheus.Labels{
+		"ResourceId":  sample.ResourceId,
+		"metricName":  sample.Metric,
+		"aggregation": sample.Aggregation,
+		"unit":        sample.Unit,
+	}
+	// The parts of the resource ID let PromQL aggregate by subscription, resource group or type
+	if id, err := resourceid.Parse(sample.ResourceId); err == nil {
+		for k, v := range id.Labels() {
+			labels[k] = v
+		}
+	}
+	// Dimensions whose names sanitize to the same label, e.g. LUN and lun, are disambiguated
+	// in the order of their names, so that the series identity is the same at every poll
+	dimensions := make([]string, 0, len(sample.Labels))
+	for dimension := range sample.Labels {
+		dimensions = append(dimensions, dimension)
+	}
+	sort.Strings(dimensions)
+	for _, dimension := range dimensions {
+		label := dimensionLabel(dimension)
+		for i := 2; ; i++ {
+			if _, ok := labels[label]; !ok {
+				break
+			}
+			label = dimensionLabel(dimension) + "_" + strconv.Itoa(i)
+		}
+		labels[label] = sample.Labels[dimension]
+	}
+	return labels
+}
+
+// dimensionLabel returns the label name used for an Azure Monitor dimension,
+// prefixed when it would collide with one of the fixed labels
+func dimensionLabel(name string) string {
+	label := utils.SanitizeLabelName(name)
+	for _, fixed := range fixedLabels {
+		if strings.EqualFold(label, fixed) {
+			return "dimension_" + label
+		}
+	}
+	return label
+}
+
+// seriesKey identifies a series by its name and labels, so that new datapoints of the same
+// resource, metric, unit, aggregation and dimensions update the same series
+func seriesKey(name string, labels prometheus.Labels) string {
+	key := strconv.Quote(name)
+	for _, k := range sortedKeys(labels) {
+		key += "," + strconv.Quote(k) + "=" + strconv.Quote(labels[k])
+	}
+	return key
+}
+
+func sortedKeys(labels prometheus.Labels) []string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,104 @@
+package collector
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	configmetrics "github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestSeriesLabelsDimensionCollisions(t *testing.T) {
+	sample := configmetrics.Sample{
+		ResourceId: "/subscriptions/s/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm",
+		Metric:     "Disk Read Bytes",
+		Labels: map[string]string{
+			"LUN":      "0",
+			"lun":      "1",
+			"API Name": "GetBlob",
+			"API_Name": "PutBlob",
+			"unit":     "2",
+		},
+	}
+
+	want := map[string]string{
+		"lun":            "0",
+		"lun_2":          "1",
+		"api_name":       "GetBlob",
+		"api_name_2":     "PutBlob",
+		"dimension_unit": "2",
+	}
+	first := seriesLabels(sample)
+	for label, value := range want {
+		if first[label] != value {
+			t.Errorf("label %s = %q, want %q", label, first[label], value)
+		}
+	}
+
+	// The identity does not depend on the map iteration order
+	for i := 0; i < 20; i++ {
+		if got := seriesLabels(sample); !reflect.DeepEqual(got, first) {
+			t.Fatalf("seriesLabels() = %v, want %v", got, first)
+		}
+	}
+}
+
+func TestGatherer(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	samples := []configmetrics.Sample{}
+	for i := 0; i < 3; i++ {
+		samples = append(samples, configmetrics.Sample{
+			ResourceId:  "/subscriptions/s/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm",
+			Metric:      "Percentage CPU",
+			Aggregation: "Average",
+			Unit:        "Percent",
+			Timestamp:   start.Add(time.Duration(i) * time.Minute),
+			Value:       float64(i),
+		})
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(prometheus.NewCounter(prometheus.CounterOpts{Name: "exporter_scrapes_total"}))
+
+	tests := []struct {
+		name       string
+		fullWindow bool
+		want       []float64
+	}{
+		{name: "latest only", want: []float64{2}},
+		{name: "full window", fullWindow: true, want: []float64{0, 1, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New()
+			c.Update(samples, Options{FullWindow: tt.fullWindow, StaleSeriesGracePeriod: time.Hour})
+
+			mfs, err := c.Gatherer(registry).Gather()
+			if err != nil {
+				t.Fatal(err)
+			}
+			names := []string{}
+			values := []float64{}
+			for _, mf := range mfs {
+				names = append(names, mf.GetName())
+				if mf.GetName() != "percentage_cpu" {
+					continue
+				}
+				for _, m := range mf.GetMetric() {
+					values = append(values, m.GetGauge().GetValue())
+					if got, want := m.GetTimestampMs(), start.Add(time.Duration(m.GetGauge().GetValue())*time.Minute).UnixMilli(); got != want {
+						t.Errorf("timestamp = %d, want %d", got, want)
+					}
+				}
+			}
+			if want := []string{"exporter_scrapes_total", "percentage_cpu"}; !reflect.DeepEqual(names, want) {
+				t.Errorf("families = %v, want %v", names, want)
+			}
+			if !reflect.DeepEqual(values, tt.want) {
+				t.Errorf("values = %v, want %v", values, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,410 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/helpers/kube/httpcall"
+	"github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/resourceid"
+	"github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/utils"
+
+	finopsdatatypes "github.com/krateoplatformops/finops-data-types/api/v1"
+)
+
+const (
+	DefaultStaleSeriesGracePeriod = 10 * time.Minute
+	DefaultMaxConcurrency         = 10
+	DefaultShutdownTimeout        = 30 * time.Second
+	DefaultRequestTimeout         = 1 * time.Minute
+	DefaultReadinessIntervals     = 3
+	DefaultMinScrapeInterval      = 30 * time.Second
+	DefaultRemoteWriteBatchSize   = 2000
+	DefaultRemoteWriteTimeout     = 30 * time.Second
+	DefaultOTLPTimeout            = 30 * time.Second
+	DefaultDiscoveryCacheTTL      = 1 * time.Hour
+	DefaultDiscoveryAPIVersion    = "2018-01-01"
+	DefaultResourceGraphRefresh   = 1 * time.Hour
+	DefaultResourceGraphVersion   = "2021-03-01"
+	DefaultTagsCacheTTL           = 1 * time.Hour
+	DefaultTagsAPIVersion         = "2021-04-01"
+	DefaultBatchScope             = "https://metrics.monitor.azure.com/.default"
+
+	// ModePush scrapes Azure every polling interval
+	ModePush = "push"
+	// ModePull scrapes Azure when Prometheus scrapes the exporter
+	ModePull = "pull"
+
+	// TagsModeLabels adds the tags to the labels of every series, TagsModeInfo exports them
+	// on a separate azure_resource_info series per resource
+	TagsModeLabels = "labels"
+	TagsModeInfo   = "info"
+
+	// OTLPProtocolGRPC and OTLPProtocolHTTP are the supported OTLP transports
+	OTLPProtocolGRPC = "grpc"
+	OTLPProtocolHTTP = "http/protobuf"
+
+	// MaxBatchResources is the maximum number of resources a metrics:getBatch request accepts
+	MaxBatchResources = 50
+	// MaxMetricsPerRequest is the maximum number of metric names a metrics request accepts
+	MaxMetricsPerRequest = 20
+)
+
+// Config is the content of the exporter configuration file: the ExporterScraperConfig
+// generated by the FinOps operator, plus the optional settings only this exporter reads.
+type Config struct {
+	finopsdatatypes.ExporterScraperConfig `yaml:",inline"`
+
+	Exporter Options `yaml:"exporter"`
+}
+
+type Options struct {
+	// Mode is either push (default) or pull
+	Mode string `yaml:"mode"`
+	// MinScrapeInterval is, in pull mode, the minimum time between two Azure scrapes:
+	// more frequent Prometheus scrapes are served the samples of the last one
+	MinScrapeInterval time.Duration `yaml:"minScrapeInterval"`
+	// StaleSeriesGracePeriod is how long a series missing from the Azure response
+	// is still exported before being removed
+	StaleSeriesGracePeriod time.Duration `yaml:"staleSeriesGracePeriod"`
+	// FullWindow exports every datapoint of the timeseries window returned by Azure,
+	// each with its own timestamp, instead of only the latest one
+	FullWindow bool `yaml:"fullWindow"`
+	// Resources lists the Azure resources to scrape, each with its own variables.
+	// When empty, only the resource described by spec.exporterConfig.additionalVariables is scraped
+	Resources []Resource `yaml:"resources"`
+	// MaxConcurrency is the maximum number of resources scraped at the same time
+	MaxConcurrency int `yaml:"maxConcurrency"`
+	// RequestTimeout bounds every Azure API call, unless overridden by the resource or the batch configuration
+	RequestTimeout time.Duration `yaml:"requestTimeout"`
+	// ReadinessIntervals is how many polling intervals may pass without a successful
+	// Azure scrape before the exporter is reported as not ready
+	ReadinessIntervals int `yaml:"readinessIntervals"`
+	// ShutdownTimeout is how long in-flight scrapes and HTTP requests are waited for on shutdown
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+	// Retry is the retry policy of the Azure API calls
+	Retry httpcall.RetryPolicy `yaml:"retry"`
+	// RemoteWrite, when its URL is set, also pushes the samples to a Prometheus remote write receiver
+	RemoteWrite RemoteWrite `yaml:"remoteWrite"`
+	// OTLP, when its endpoint is set, also pushes the samples to an OpenTelemetry collector
+	OTLP OTLP `yaml:"otlp"`
+	// ResourceGraph discovers the resources to scrape with an Azure Resource Graph query,
+	// instead of reading them from Resources
+	ResourceGraph ResourceGraph `yaml:"resourceGraph"`
+	// Tags exports the allowed tags of the resources
+	Tags Tags `yaml:"tags"`
+	// Discovery builds the metricnames of the requests from the metrics the resources support
+	Discovery Discovery `yaml:"discovery"`
+	// Batch scrapes the resources through the Azure Monitor metrics:getBatch data-plane API
+	Batch Batch `yaml:"batch"`
+}
+
+// Batch configures the metrics:getBatch mode. The variables of each resource are replaced
+// in ServerURL and Path, and the resources sharing the same request and resource type
+// are grouped in the same call.
+type Batch struct {
+	Enabled bool `yaml:"enabled"`
+	// ServerURL is the regional data-plane endpoint, e.g. https://<Region>.metrics.monitor.azure.com
+	ServerURL string `yaml:"serverUrl"`
+	// Path is the request path, e.g.
+	// subscriptions/<SubscriptionId>/metrics:getBatch?metricnamespace=...&metricnames=...&api-version=2023-10-01
+	Path string `yaml:"path"`
+	// RequestTimeout overrides exporter.requestTimeout for the metrics:getBatch calls
+	RequestTimeout time.Duration `yaml:"requestTimeout"`
+	// Scope is the Azure AD scope of the tokens of the metrics:getBatch calls, which the
+	// data-plane endpoint requires instead of the Azure Resource Manager one
+	Scope string `yaml:"scope"`
+}
+
+// RemoteWrite configures the Prometheus remote write output.
+type RemoteWrite struct {
+	URL string `yaml:"url"`
+	// Headers are added to every request, in the "Name: value" format, e.g. for authentication
+	Headers []string `yaml:"headers"`
+	// BatchSize is the maximum number of samples of a single WriteRequest
+	BatchSize int `yaml:"batchSize"`
+	// Timeout bounds every request
+	Timeout time.Duration `yaml:"timeout"`
+	// Retry is the retry policy of the requests
+	Retry httpcall.RetryPolicy `yaml:"retry"`
+}
+
+// OTLP configures the OpenTelemetry metrics output.
+type OTLP struct {
+	// Endpoint is the URL of the collector, e.g. http://otel-collector:4317 for gRPC
+	// or http://otel-collector:4318/v1/metrics for HTTP
+	Endpoint string `yaml:"endpoint"`
+	// Protocol is either grpc (default) or http/protobuf
+	Protocol string `yaml:"protocol"`
+	// Insecure disables TLS
+	Insecure bool `yaml:"insecure"`
+	// Headers are added to every request, in the "Name: value" format
+	Headers []string `yaml:"headers"`
+	// Timeout bounds every export, retries included
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Discovery configures the metric discovery: the metrics of every resource are listed through
+// the metricDefinitions API, and the metricnames and aggregation query parameters of the
+// API path are replaced accordingly, splitting the request when there are too many metrics.
+type Discovery struct {
+	Enabled bool `yaml:"enabled"`
+	// Include are the glob patterns (see utils.Glob) of the metric names to scrape, all of them when empty
+	Include []string `yaml:"include"`
+	// Exclude are the glob patterns of the metric names not to scrape, applied after Include
+	Exclude []string `yaml:"exclude"`
+	// Aggregations are the preferred aggregation types, in order: every metric is scraped with the
+	// first one it supports, or with its primary aggregation type when it supports none of them
+	Aggregations []string `yaml:"aggregations"`
+	// CacheTTL is how long the metric definitions of a resource are reused
+	CacheTTL time.Duration `yaml:"cacheTTL"`
+	// APIVersion is the version of the metricDefinitions API
+	APIVersion string `yaml:"apiVersion"`
+}
+
+// Tags configures the export of the resource tags, read through the Tags API.
+type Tags struct {
+	Enabled bool `yaml:"enabled"`
+	// Allow are the names of the tags to export, e.g. costcenter, case-insensitive
+	Allow []string `yaml:"allow"`
+	// Mode is either info (default) or labels
+	Mode string `yaml:"mode"`
+	// CacheTTL is how long the tags of a resource are reused
+	CacheTTL time.Duration `yaml:"cacheTTL"`
+	// APIVersion is the version of the Tags API
+	APIVersion string `yaml:"apiVersion"`
+}
+
+// ResourceGraph configures the resource discovery. Every row returned by the query is a
+// resource to scrape, whose columns are available as variables, besides ResourceId.
+type ResourceGraph struct {
+	Enabled bool `yaml:"enabled"`
+	// Query is the KQL query, e.g. resources | where tags.finops == 'true'. It must return the id and type columns
+	Query string `yaml:"query"`
+	// Subscriptions and ManagementGroups are the scope of the query, the ones the credentials can access when both are empty
+	Subscriptions    []string `yaml:"subscriptions"`
+	ManagementGroups []string `yaml:"managementGroups"`
+	// RefreshInterval is how often the query runs again to update the resources
+	RefreshInterval time.Duration `yaml:"refreshInterval"`
+	// APIVersion is the version of the Resource Graph API
+	APIVersion string `yaml:"apiVersion"`
+	// Templates describe how to scrape each resource type. The resources of a type without template are skipped
+	Templates []ResourceTemplate `yaml:"templates"`
+}
+
+// ResourceTemplate is how the discovered resources of a type are scraped.
+type ResourceTemplate struct {
+	// Type is the resource type, e.g. microsoft.compute/virtualmachines; the template of an empty type applies to the other types
+	Type string `yaml:"type"`
+	// Path replaces spec.exporterConfig.api.path for these resources
+	Path string `yaml:"path"`
+	// AdditionalVariables are added to the variables of these resources
+	AdditionalVariables map[string]string `yaml:"additionalVariables"`
+	// RequestTimeout overrides exporter.requestTimeout for the calls of these resources
+	RequestTimeout time.Duration `yaml:"requestTimeout"`
+}
+
+type Resource struct {
+	// Path, when set, replaces spec.exporterConfig.api.path for this resource
+	Path string `yaml:"path"`
+	// AdditionalVariables override, for this resource, the ones in spec.exporterConfig.additionalVariables
+	AdditionalVariables map[string]string `yaml:"additionalVariables"`
+	// RequestTimeout overrides exporter.requestTimeout for the calls of this resource
+	RequestTimeout time.Duration `yaml:"requestTimeout"`
+}
+
+// Target is a single Azure API call to make, with the variables already replaced in the API path.
+type Target struct {
+	ResourceId string
+	Variables  map[string]string
+	API        finopsdatatypes.API
+	// ServerURL, when set, replaces the server URL of the endpoint
+	ServerURL string
+	// Scope, when set, replaces the Azure AD scope of the endpoint
+	Scope string
+	// ResourceIds are the resources of a metrics:getBatch request
+	ResourceIds []string
+	// Timeout bounds the API call
+	Timeout time.Duration
+}
+
+// Fetch makes an Azure API call and returns the response body.
+type Fetch func(ctx context.Context, target Target) ([]byte, error)
+
+// IsBatch returns whether the target is a metrics:getBatch request.
+func (t *Target) IsBatch() bool {
+	return len(t.ResourceIds) > 0
+}
+
+// Default sets the default value of every option that has not been configured.
+func (o *Options) Default() {
+	if o.Mode == "" {
+		o.Mode = ModePush
+	}
+	if o.MinScrapeInterval <= 0 {
+		o.MinScrapeInterval = DefaultMinScrapeInterval
+	}
+	if o.StaleSeriesGracePeriod <= 0 {
+		o.StaleSeriesGracePeriod = DefaultStaleSeriesGracePeriod
+	}
+	if o.MaxConcurrency <= 0 {
+		o.MaxConcurrency = DefaultMaxConcurrency
+	}
+	if o.RequestTimeout <= 0 {
+		o.RequestTimeout = DefaultRequestTimeout
+	}
+	if o.ReadinessIntervals <= 0 {
+		o.ReadinessIntervals = DefaultReadinessIntervals
+	}
+	if o.ShutdownTimeout <= 0 {
+		o.ShutdownTimeout = DefaultShutdownTimeout
+	}
+	o.Retry.Default()
+
+	if o.Batch.Scope == "" {
+		o.Batch.Scope = DefaultBatchScope
+	}
+
+	if o.RemoteWrite.BatchSize <= 0 {
+		o.RemoteWrite.BatchSize = DefaultRemoteWriteBatchSize
+	}
+	if o.RemoteWrite.Timeout <= 0 {
+		o.RemoteWrite.Timeout = DefaultRemoteWriteTimeout
+	}
+	o.RemoteWrite.Retry.Default()
+
+	if o.OTLP.Protocol == "" {
+		o.OTLP.Protocol = OTLPProtocolGRPC
+	}
+	if o.OTLP.Timeout <= 0 {
+		o.OTLP.Timeout = DefaultOTLPTimeout
+	}
+
+	if o.Discovery.CacheTTL <= 0 {
+		o.Discovery.CacheTTL = DefaultDiscoveryCacheTTL
+	}
+	if o.Discovery.APIVersion == "" {
+		o.Discovery.APIVersion = DefaultDiscoveryAPIVersion
+	}
+
+	if o.ResourceGraph.RefreshInterval <= 0 {
+		o.ResourceGraph.RefreshInterval = DefaultResourceGraphRefresh
+	}
+	if o.ResourceGraph.APIVersion == "" {
+		o.ResourceGraph.APIVersion = DefaultResourceGraphVersion
+	}
+
+	if o.Tags.Mode == "" {
+		o.Tags.Mode = TagsModeInfo
+	}
+	if o.Tags.CacheTTL <= 0 {
+		o.Tags.CacheTTL = DefaultTagsCacheTTL
+	}
+	if o.Tags.APIVersion == "" {
+		o.Tags.APIVersion = DefaultTagsAPIVersion
+	}
+}
+
+// Targets returns the API calls to make: one per resource or, in batch mode,
+// one per group of resources.
+func (c *Config) Targets() []Target {
+	return c.TargetsOf(c.Exporter.Resources)
+}
+
+// TargetsOf returns the API calls to make for the given resources, e.g. the discovered ones.
+func (c *Config) TargetsOf(resources []Resource) []Target {
+	if c.Exporter.Batch.Enabled {
+		return c.batchTargets(resources)
+	}
+	return c.resourceTargets(resources)
+}
+
+func (c *Config) resourceTargets(resources []Resource) []Target {
+	if len(resources) == 0 {
+		resources = []Resource{{}}
+	}
+
+	res := make([]Target, 0, len(resources))
+	for _, resource := range resources {
+		variables := map[string]string{}
+		for k, v := range c.Spec.ExporterConfig.AdditionalVariables {
+			variables[k] = v
+		}
+		for k, v := range resource.AdditionalVariables {
+			variables[k] = v
+		}
+
+		api := c.Spec.ExporterConfig.API
+		if resource.Path != "" {
+			api.Path = resource.Path
+		}
+		api.Path = utils.ReplaceVariables(api.Path, variables)
+
+		timeout := c.Exporter.RequestTimeout
+		if resource.RequestTimeout > 0 {
+			timeout = resource.RequestTimeout
+		}
+
+		res = append(res, Target{
+			ResourceId: variables["ResourceId"],
+			Variables:  variables,
+			API:        api,
+			Timeout:    timeout,
+		})
+	}
+	return res
+}
+
+func (c *Config) batchTargets(resources []Resource) []Target {
+	groups := map[string]*Target{}
+	res := []Target{}
+	flush := func(key string) {
+		if group, ok := groups[key]; ok {
+			payload, _ := json.Marshal(map[string][]string{"resourceids": group.ResourceIds})
+			group.API.Payload = string(payload)
+			res = append(res, *group)
+			delete(groups, key)
+		}
+	}
+
+	timeout := c.Exporter.RequestTimeout
+	if c.Exporter.Batch.RequestTimeout > 0 {
+		timeout = c.Exporter.Batch.RequestTimeout
+	}
+
+	keys := []string{}
+	for _, target := range c.resourceTargets(resources) {
+		serverURL := utils.ReplaceVariables(c.Exporter.Batch.ServerURL, target.Variables)
+		path := utils.ReplaceVariables(c.Exporter.Batch.Path, target.Variables)
+		key := strings.ToLower(serverURL + " " + path + " " + resourceid.Type(target.ResourceId))
+
+		group, ok := groups[key]
+		if !ok {
+			group = &Target{
+				Variables: target.Variables,
+				API: finopsdatatypes.API{
+					Path:        path,
+					Verb:        "POST",
+					Headers:     []string{"Content-Type:application/json"},
+					EndpointRef: target.API.EndpointRef,
+				},
+				ServerURL: serverURL,
+				Scope:     c.Exporter.Batch.Scope,
+				Timeout:   timeout,
+			}
+			groups[key] = group
+			keys = append(keys, key)
+		}
+		group.ResourceIds = append(group.ResourceIds, target.ResourceId)
+
+		if len(group.ResourceIds) == MaxBatchResources {
+			flush(key)
+		}
+	}
+
+	for _, key := range keys {
+		flush(key)
+	}
+	return res
+}
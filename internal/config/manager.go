@@ -0,0 +1,191 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/selfmetrics"
+	"github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/utils"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+// ParseFile reads, parses and validates the configuration file.
+func ParseFile(file string) (*Config, []byte, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	parse := &Config{}
+	if err := yaml.Unmarshal(data, parse); err != nil {
+		return nil, data, err
+	}
+	parse.Exporter.Default()
+
+	if err := parse.Validate(); err != nil {
+		return nil, data, err
+	}
+	return parse, data, nil
+}
+
+// Validate returns an error when the configuration cannot be used to scrape Azure.
+func (c *Config) Validate() error {
+	if c.Exporter.Mode != ModePush && c.Exporter.Mode != ModePull {
+		return fmt.Errorf("exporter.mode must be either %s or %s", ModePush, ModePull)
+	}
+	if c.Exporter.OTLP.Protocol != OTLPProtocolGRPC && c.Exporter.OTLP.Protocol != OTLPProtocolHTTP {
+		return fmt.Errorf("exporter.otlp.protocol must be either %s or %s", OTLPProtocolGRPC, OTLPProtocolHTTP)
+	}
+
+	if c.Exporter.Tags.Mode != TagsModeInfo && c.Exporter.Tags.Mode != TagsModeLabels {
+		return fmt.Errorf("exporter.tags.mode must be either %s or %s", TagsModeInfo, TagsModeLabels)
+	}
+	if c.Exporter.Tags.Enabled && len(c.Exporter.Tags.Allow) == 0 {
+		return fmt.Errorf("exporter.tags requires the allow list of the tags to export")
+	}
+
+	for _, patterns := range [][]string{c.Exporter.Discovery.Include, c.Exporter.Discovery.Exclude} {
+		for _, pattern := range patterns {
+			if _, err := utils.Glob(pattern); err != nil {
+				return fmt.Errorf("exporter.discovery: %w", err)
+			}
+		}
+	}
+
+	if c.Spec.ExporterConfig.PollingInterval.Duration <= 0 {
+		return fmt.Errorf("spec.exporterConfig.pollingInterval must be greater than zero")
+	}
+
+	if c.Exporter.ResourceGraph.Enabled {
+		if c.Exporter.ResourceGraph.Query == "" {
+			return fmt.Errorf("exporter.resourceGraph requires query")
+		}
+		if len(c.Exporter.ResourceGraph.Templates) == 0 {
+			return fmt.Errorf("exporter.resourceGraph requires at least one template")
+		}
+	}
+
+	if !c.Exporter.Batch.Enabled {
+		if c.Spec.ExporterConfig.API.Path != "" {
+			return nil
+		}
+		if !c.Exporter.ResourceGraph.Enabled {
+			return fmt.Errorf("spec.exporterConfig.api.path is required")
+		}
+		for i, template := range c.Exporter.ResourceGraph.Templates {
+			if template.Path == "" {
+				return fmt.Errorf("exporter.resourceGraph.templates[%d].path is required without spec.exporterConfig.api.path", i)
+			}
+		}
+		return nil
+	}
+
+	if c.Exporter.Batch.ServerURL == "" || c.Exporter.Batch.Path == "" {
+		return fmt.Errorf("exporter.batch requires serverUrl and path")
+	}
+	if c.Exporter.ResourceGraph.Enabled {
+		// The discovered resources always have an ID
+		return nil
+	}
+	for i, target := range c.resourceTargets(c.Exporter.Resources) {
+		if target.ResourceId == "" {
+			return fmt.Errorf("missing ResourceId variable for resource %d, required by exporter.batch", i)
+		}
+	}
+	return nil
+}
+
+// Manager holds the active configuration, reloading it when the file changes.
+// An invalid file does not replace the last valid configuration.
+type Manager struct {
+	file    string
+	current atomic.Pointer[Config]
+
+	mu   sync.Mutex
+	data []byte
+}
+
+func NewManager(file string) *Manager {
+	return &Manager{file: file}
+}
+
+// Current returns the active configuration, nil if none has been loaded yet. The returned
+// configuration must not be modified: a reload replaces it with a new one.
+func (m *Manager) Current() *Config {
+	return m.current.Load()
+}
+
+// Load parses the configuration file and, when valid, makes it the active configuration.
+func (m *Manager) Load() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	config, data, err := ParseFile(m.file)
+	if err != nil {
+		selfmetrics.ConfigLastReloadSuccessful.Set(0)
+		return err
+	}
+
+	selfmetrics.ConfigLastReloadSuccessful.Set(1)
+	selfmetrics.ConfigLastReloadSuccessTimestamp.SetToCurrentTime()
+
+	if m.current.Load() != nil && bytes.Equal(data, m.data) {
+		return nil
+	}
+	m.data = data
+	m.current.Store(config)
+	log.Logger.Info().Msgf("Configuration %s loaded", m.file)
+	return nil
+}
+
+// Watch reloads the configuration whenever the file changes, until the context is done.
+// The directory is watched rather than the file, since ConfigMap volumes update
+// the file by swapping the ..data symlink.
+func (m *Manager) Watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(filepath.Dir(m.file)); err != nil {
+		return err
+	}
+
+	// Changes usually come as bursts of events, a single reload is enough for them
+	const debounce = 500 * time.Millisecond
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Has(fsnotify.Chmod) {
+				continue
+			}
+			timer.Reset(debounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Logger.Warn().Err(err).Msg("error while watching the configuration")
+		case <-timer.C:
+			if err := m.Load(); err != nil {
+				log.Logger.Error().Err(err).Msgf("invalid configuration %s, keeping the last valid one", m.file)
+			}
+		}
+	}
+}
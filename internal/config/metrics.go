@@ -9,6 +9,18 @@ type Metrics struct {
 	Value []Value `json:"value"`
 }
 
+// BatchMetrics is the response of the metrics:getBatch API: the metrics of each requested resource.
+type BatchMetrics struct {
+	Values []BatchValue `json:"values"`
+}
+
+type BatchValue struct {
+	ResourceId     string  `json:"resourceid"`
+	ResourceRegion string  `json:"resourceregion"`
+	Namespace      string  `json:"namespace"`
+	Value          []Value `json:"value"`
+}
+
 type Value struct {
 	Id         string       `json:"id"`
 	Name       Name         `json:"name"`
@@ -22,10 +34,62 @@ type Name struct {
 }
 
 type Timeseries struct {
-	Data []Data `json:"data"`
+	Metadatavalues []MetadataValue `json:"metadatavalues"`
+	Data           []Data          `json:"data"`
 }
 
+// MetadataValue is the value of a dimension the timeseries has been split by,
+// returned when the query contains a $filter such as "LUN eq '*'".
+type MetadataValue struct {
+	Name  Name   `json:"name"`
+	Value string `json:"value"`
+}
+
+// Data is a single datapoint of a timeseries. Azure Monitor only returns the
+// aggregation types requested through the "aggregation" query parameter, so
+// the missing ones are left nil.
 type Data struct {
-	Timestamp metav1.Time       `json:"timeStamp"`
-	Average   resource.Quantity `json:"average"`
+	Timestamp metav1.Time        `json:"timeStamp"`
+	Average   *resource.Quantity `json:"average,omitempty"`
+	Minimum   *resource.Quantity `json:"minimum,omitempty"`
+	Maximum   *resource.Quantity `json:"maximum,omitempty"`
+	Total     *resource.Quantity `json:"total,omitempty"`
+	Count     *resource.Quantity `json:"count,omitempty"`
+}
+
+// Aggregation is the value of a datapoint for one aggregation type.
+type Aggregation struct {
+	Name  string
+	Value *resource.Quantity
+}
+
+// Aggregations returns the aggregation types present in the datapoint, in a
+// stable order and named as in the Azure Monitor "aggregation" query parameter.
+func (d *Data) Aggregations() []Aggregation {
+	res := []Aggregation{}
+	for _, a := range []Aggregation{
+		{Name: "average", Value: d.Average},
+		{Name: "minimum", Value: d.Minimum},
+		{Name: "maximum", Value: d.Maximum},
+		{Name: "total", Value: d.Total},
+		{Name: "count", Value: d.Count},
+	} {
+		if a.Value != nil {
+			res = append(res, a)
+		}
+	}
+	return res
+}
+
+// MetricDefinitions is the response of the metricDefinitions API: the metrics a resource supports.
+type MetricDefinitions struct {
+	Value []MetricDefinition `json:"value"`
+}
+
+type MetricDefinition struct {
+	Name                      Name     `json:"name"`
+	Namespace                 string   `json:"namespace"`
+	Unit                      string   `json:"unit"`
+	PrimaryAggregationType    string   `json:"primaryAggregationType"`
+	SupportedAggregationTypes []string `json:"supportedAggregationTypes"`
 }
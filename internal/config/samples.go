@@ -0,0 +1,88 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Sample is the value of an Azure Monitor metric for one datapoint and aggregation type.
+type Sample struct {
+	ResourceId  string
+	Metric      string
+	Unit        string
+	Aggregation string
+	Timestamp   time.Time
+	Value       float64
+	// Labels holds the dimensions the timeseries has been split by, keyed by dimension name,
+	// plus the tag labels of the resource when exporter.tags.mode is labels
+	Labels map[string]string
+}
+
+// Decode parses an Azure Monitor metrics response into the samples of the given resource.
+func Decode(data []byte, resourceId string) ([]Sample, error) {
+	metrics := Metrics{}
+	if err := json.Unmarshal(data, &metrics); err != nil {
+		if e, ok := err.(*json.SyntaxError); ok {
+			return nil, fmt.Errorf("syntax error at byte offset %d: %w", e.Offset, err)
+		}
+		return nil, err
+	}
+	return metrics.Samples(resourceId), nil
+}
+
+// DecodeBatch parses a metrics:getBatch response into the samples of each resource. Azure
+// may change the case of the resource IDs, so they are matched with the requested ones.
+func DecodeBatch(data []byte, resourceIds []string) ([]Sample, error) {
+	batch := BatchMetrics{}
+	if err := json.Unmarshal(data, &batch); err != nil {
+		if e, ok := err.(*json.SyntaxError); ok {
+			return nil, fmt.Errorf("syntax error at byte offset %d: %w", e.Offset, err)
+		}
+		return nil, err
+	}
+
+	res := []Sample{}
+	for _, value := range batch.Values {
+		resourceId := value.ResourceId
+		for _, requested := range resourceIds {
+			if strings.EqualFold(strings.Trim(requested, "/"), strings.Trim(resourceId, "/")) {
+				resourceId = requested
+				break
+			}
+		}
+
+		metrics := Metrics{Value: value.Value}
+		res = append(res, metrics.Samples(resourceId)...)
+	}
+	return res, nil
+}
+
+// Samples returns a sample for every aggregation type of every datapoint in the response.
+func (m *Metrics) Samples(resourceId string) []Sample {
+	res := []Sample{}
+	for _, value := range m.Value {
+		for _, timeseries := range value.Timeseries {
+			labels := map[string]string{}
+			for _, metadata := range timeseries.Metadatavalues {
+				labels[metadata.Name.Value] = metadata.Value
+			}
+
+			for _, data := range timeseries.Data {
+				for _, aggregation := range data.Aggregations() {
+					res = append(res, Sample{
+						ResourceId:  resourceId,
+						Metric:      value.Name.Value,
+						Unit:        value.Unit,
+						Aggregation: aggregation.Name,
+						Timestamp:   data.Timestamp.Time,
+						Value:       aggregation.Value.AsApproximateFloat64(),
+						Labels:      labels,
+					})
+				}
+			}
+		}
+	}
+	return res
+}
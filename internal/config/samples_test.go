@@ -0,0 +1,227 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// metricsResponse is a metrics response of a VM with dimensions, trimmed to two datapoints. The
+// metric name, the unit and the dimension values contain commas and quotes, which Azure returns as is.
+const metricsResponse = `{
+  "cost": 59,
+  "timespan": "2024-09-10T08:00:00Z/2024-09-10T09:00:00Z",
+  "interval": "PT1M",
+  "value": [
+    {
+      "id": "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg-finops/providers/Microsoft.Compute/virtualMachines/vm,\"1\"/providers/Microsoft.Insights/metrics/Disk Read Bytes, \"LUN\"",
+      "type": "Microsoft.Insights/metrics",
+      "name": {"value": "Disk Read Bytes, \"LUN\"", "localizedValue": "Disk Read Bytes"},
+      "displayDescription": "Bytes read from disk",
+      "unit": "Bytes, \"raw\"",
+      "timeseries": [
+        {
+          "metadatavalues": [
+            {"name": {"value": "LUN", "localizedValue": "LUN"}, "value": "0,\"data\""}
+          ],
+          "data": [
+            {"timeStamp": "2024-09-10T08:00:00Z", "average": 1024.5, "maximum": 2048},
+            {"timeStamp": "2024-09-10T08:01:00Z", "maximum": 4096}
+          ]
+        }
+      ],
+      "errorCode": "Success"
+    },
+    {
+      "id": "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg-finops/providers/Microsoft.Compute/virtualMachines/vm,\"1\"/providers/Microsoft.Insights/metrics/Percentage CPU",
+      "type": "Microsoft.Insights/metrics",
+      "name": {"value": "Percentage CPU", "localizedValue": "Percentage CPU"},
+      "unit": "Percent",
+      "timeseries": [],
+      "errorCode": "Success"
+    }
+  ],
+  "namespace": "Microsoft.Compute/virtualMachines",
+  "resourceregion": "westeurope"
+}`
+
+// batchResponse is a metrics:getBatch response: Azure returns the resource IDs lower-cased.
+const batchResponse = `{
+  "values": [
+    {
+      "starttime": "2024-09-10T08:00:00Z",
+      "endtime": "2024-09-10T09:00:00Z",
+      "interval": "PT1H",
+      "value": [
+        {
+          "id": "subscriptions/00000000-0000-0000-0000-000000000000/resourcegroups/rg-finops/providers/microsoft.storage/storageaccounts/st,\"a\"/providers/Microsoft.Insights/metrics/Transactions",
+          "name": {"value": "Transactions", "localizedValue": "Transactions"},
+          "unit": "Count",
+          "timeseries": [
+            {
+              "metadatavalues": [
+                {"name": {"value": "ApiName", "localizedValue": "API name"}, "value": "GetBlob, \"v2\""}
+              ],
+              "data": [
+                {"timeStamp": "2024-09-10T08:00:00Z", "total": 42}
+              ]
+            }
+          ],
+          "errorCode": "Success"
+        }
+      ],
+      "namespace": "microsoft.storage/storageaccounts",
+      "resourceregion": "westeurope",
+      "resourceid": "/subscriptions/00000000-0000-0000-0000-000000000000/resourcegroups/rg-finops/providers/microsoft.storage/storageaccounts/st,\"a\""
+    },
+    {
+      "value": [
+        {
+          "name": {"value": "Transactions", "localizedValue": "Transactions"},
+          "unit": "Count",
+          "timeseries": [
+            {"data": [{"timeStamp": "2024-09-10T08:00:00Z", "total": 7}]}
+          ]
+        }
+      ],
+      "resourceid": "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/other/providers/Microsoft.Storage/storageAccounts/unrequested"
+    }
+  ]
+}`
+
+const (
+	vmId      = `/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg-finops/providers/Microsoft.Compute/virtualMachines/vm,"1"`
+	storageId = `/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/rg-finops/providers/Microsoft.Storage/storageAccounts/st,"a"`
+)
+
+func timestamp(t *testing.T, value string) time.Time {
+	t.Helper()
+	res, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+// utc converts the sample timestamps, which metav1.Time decodes in the local time zone
+func utc(samples []Sample) []Sample {
+	for i := range samples {
+		samples[i].Timestamp = samples[i].Timestamp.UTC()
+	}
+	return samples
+}
+
+func TestDecode(t *testing.T) {
+	lun := map[string]string{"LUN": `0,"data"`}
+
+	tests := []struct {
+		name    string
+		data    string
+		want    []Sample
+		wantErr bool
+	}{
+		{
+			name: "dimensions, partial aggregations and empty timeseries",
+			data: metricsResponse,
+			want: []Sample{
+				{ResourceId: vmId, Metric: `Disk Read Bytes, "LUN"`, Unit: `Bytes, "raw"`, Aggregation: "average", Timestamp: timestamp(t, "2024-09-10T08:00:00Z"), Value: 1024.5, Labels: lun},
+				{ResourceId: vmId, Metric: `Disk Read Bytes, "LUN"`, Unit: `Bytes, "raw"`, Aggregation: "maximum", Timestamp: timestamp(t, "2024-09-10T08:00:00Z"), Value: 2048, Labels: lun},
+				{ResourceId: vmId, Metric: `Disk Read Bytes, "LUN"`, Unit: `Bytes, "raw"`, Aggregation: "maximum", Timestamp: timestamp(t, "2024-09-10T08:01:00Z"), Value: 4096, Labels: lun},
+			},
+		},
+		{
+			name: "no metrics",
+			data: `{"value": []}`,
+			want: []Sample{},
+		},
+		{
+			name:    "truncated response",
+			data:    metricsResponse[:200],
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decode([]byte(tt.data), vmId)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(utc(got), tt.want) {
+				t.Errorf("Decode() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeBatch(t *testing.T) {
+	unrequested := "/subscriptions/00000000-0000-0000-0000-000000000000/resourceGroups/other/providers/Microsoft.Storage/storageAccounts/unrequested"
+
+	tests := []struct {
+		name        string
+		data        string
+		resourceIds []string
+		want        []Sample
+		wantErr     bool
+	}{
+		{
+			name:        "resource IDs in a different case",
+			data:        batchResponse,
+			resourceIds: []string{storageId},
+			want: []Sample{
+				{ResourceId: storageId, Metric: "Transactions", Unit: "Count", Aggregation: "total", Timestamp: timestamp(t, "2024-09-10T08:00:00Z"), Value: 42, Labels: map[string]string{"ApiName": `GetBlob, "v2"`}},
+				{ResourceId: unrequested, Metric: "Transactions", Unit: "Count", Aggregation: "total", Timestamp: timestamp(t, "2024-09-10T08:00:00Z"), Value: 7, Labels: map[string]string{}},
+			},
+		},
+		{
+			name:        "invalid JSON",
+			data:        `{"values": [`,
+			resourceIds: []string{storageId},
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeBatch([]byte(tt.data), tt.resourceIds)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecodeBatch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(utc(got), tt.want) {
+				t.Errorf("DecodeBatch() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetricsSamples(t *testing.T) {
+	tests := []struct {
+		name    string
+		metrics Metrics
+		want    int
+	}{
+		{
+			name:    "no value",
+			metrics: Metrics{},
+			want:    0,
+		},
+		{
+			name:    "empty timeseries",
+			metrics: Metrics{Value: []Value{{Name: Name{Value: "Percentage CPU"}, Timeseries: []Timeseries{}}}},
+			want:    0,
+		},
+		{
+			name:    "datapoint without aggregations",
+			metrics: Metrics{Value: []Value{{Name: Name{Value: "Percentage CPU"}, Timeseries: []Timeseries{{Data: []Data{{}}}}}}},
+			want:    0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.metrics.Samples(vmId); len(got) != tt.want {
+				t.Errorf("Samples() returned %d samples, want %d", len(got), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package delivery
+
+import "time"
+
+// Tracker remembers the timestamp of the last sample sent of every series, so that a push
+// output sends every sample once: receivers reject the samples older than the last one of
+// their series. It is not safe for concurrent use, the outputs call it under their own lock.
+type Tracker struct {
+	lastSent map[string]time.Time
+	// lastSeen is when each series was last part of the samples, to forget the stale ones
+	lastSeen map[string]time.Time
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{lastSent: map[string]time.Time{}, lastSeen: map[string]time.Time{}}
+}
+
+// Seen records that the series is part of the samples at the given time.
+func (t *Tracker) Seen(key string, now time.Time) {
+	t.lastSeen[key] = now
+}
+
+// ShouldSend returns whether a sample of the series with the given timestamp has not been sent yet.
+func (t *Tracker) ShouldSend(key string, timestamp time.Time) bool {
+	last, ok := t.lastSent[key]
+	return !ok || timestamp.After(last)
+}
+
+// MarkSent records that the samples of the series up to the given timestamp have been delivered.
+func (t *Tracker) MarkSent(key string, timestamp time.Time) {
+	if timestamp.After(t.lastSent[key]) {
+		t.lastSent[key] = timestamp
+	}
+}
+
+// Len returns the number of series tracked.
+func (t *Tracker) Len() int {
+	return len(t.lastSeen)
+}
+
+// Forget drops the series not seen for longer than the grace period, so that the tracker
+// does not grow with the series that disappeared.
+func (t *Tracker) Forget(now time.Time, gracePeriod time.Duration) {
+	for key, seen := range t.lastSeen {
+		if now.Sub(seen) > gracePeriod {
+			delete(t.lastSeen, key)
+			delete(t.lastSent, key)
+		}
+	}
+}
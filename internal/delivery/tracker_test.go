@@ -0,0 +1,46 @@
+package delivery
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTracker(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tracker := NewTracker()
+
+	tracker.Seen("a", start)
+	if !tracker.ShouldSend("a", start) {
+		t.Fatal("ShouldSend() = false for a series never sent")
+	}
+
+	tracker.MarkSent("a", start.Add(time.Minute))
+	// An older delivery does not move the last sent timestamp back
+	tracker.MarkSent("a", start)
+	tests := []struct {
+		timestamp time.Time
+		want      bool
+	}{
+		{timestamp: start, want: false},
+		{timestamp: start.Add(time.Minute), want: false},
+		{timestamp: start.Add(2 * time.Minute), want: true},
+	}
+	for _, tt := range tests {
+		if got := tracker.ShouldSend("a", tt.timestamp); got != tt.want {
+			t.Errorf("ShouldSend(%s) = %v, want %v", tt.timestamp, got, tt.want)
+		}
+	}
+
+	// A series is forgotten only once missing for longer than the grace period
+	tracker.Forget(start.Add(10*time.Minute), 10*time.Minute)
+	if tracker.ShouldSend("a", start) {
+		t.Error("ShouldSend() = true for a series within the grace period")
+	}
+	tracker.Forget(start.Add(11*time.Minute), 10*time.Minute)
+	if tracker.Len() != 0 {
+		t.Errorf("Len() = %d after the grace period, want 0", tracker.Len())
+	}
+	if !tracker.ShouldSend("a", start) {
+		t.Error("ShouldSend() = false for a forgotten series")
+	}
+}
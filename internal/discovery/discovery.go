@@ -0,0 +1,176 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	configmetrics "github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/config"
+	"github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/utils"
+	"github.com/rs/zerolog/log"
+
+	finopsdatatypes "github.com/krateoplatformops/finops-data-types/api/v1"
+)
+
+type entry struct {
+	definitions []configmetrics.MetricDefinition
+	expires     time.Time
+}
+
+// Discoverer builds the metrics requests of a resource from its metric definitions,
+// which are cached to avoid a metricDefinitions call at every scrape.
+type Discoverer struct {
+	mu    sync.Mutex
+	cache map[string]entry
+}
+
+func NewDiscoverer() *Discoverer {
+	return &Discoverer{cache: map[string]entry{}}
+}
+
+// Targets returns the targets replacing the given one: one per aggregation type and group
+// of at most MaxMetricsPerRequest metrics. A batch target is discovered through its first
+// resource, since all the resources of a batch have the same type.
+func (d *Discoverer) Targets(ctx context.Context, opts configmetrics.Discovery, target configmetrics.Target, fetch configmetrics.Fetch) ([]configmetrics.Target, error) {
+	resourceId := target.ResourceId
+	if target.IsBatch() {
+		resourceId = target.ResourceIds[0]
+	}
+	if resourceId == "" {
+		return nil, fmt.Errorf("metric discovery requires the ResourceId variable")
+	}
+
+	definitions, err := d.definitions(ctx, opts, target, resourceId, fetch)
+	if err != nil {
+		return nil, err
+	}
+
+	byAggregation := map[string][]string{}
+	for _, def := range definitions {
+		if !Selected(def.Name.Value, opts.Include, opts.Exclude) {
+			continue
+		}
+		aggregation := Aggregation(def, opts.Aggregations)
+		byAggregation[aggregation] = append(byAggregation[aggregation], def.Name.Value)
+	}
+	if len(byAggregation) == 0 {
+		return nil, fmt.Errorf("no metric of %s matches the discovery patterns", resourceId)
+	}
+
+	aggregations := make([]string, 0, len(byAggregation))
+	for aggregation := range byAggregation {
+		aggregations = append(aggregations, aggregation)
+	}
+	sort.Strings(aggregations)
+
+	res := []configmetrics.Target{}
+	for _, aggregation := range aggregations {
+		names := byAggregation[aggregation]
+		sort.Strings(names)
+		for start := 0; start < len(names); start += configmetrics.MaxMetricsPerRequest {
+			chunk := names[start:min(start+configmetrics.MaxMetricsPerRequest, len(names))]
+
+			t := target
+			t.API.Path, err = withQuery(target.API.Path, map[string]string{
+				"metricnames": strings.Join(chunk, ","),
+				"aggregation": aggregation,
+			})
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, t)
+		}
+	}
+	return res, nil
+}
+
+// definitions returns the metric definitions of the resource, from the cache when still valid.
+func (d *Discoverer) definitions(ctx context.Context, opts configmetrics.Discovery, target configmetrics.Target, resourceId string, fetch configmetrics.Fetch) ([]configmetrics.MetricDefinition, error) {
+	key := strings.ToLower(resourceId)
+
+	d.mu.Lock()
+	cached, ok := d.cache[key]
+	d.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.definitions, nil
+	}
+
+	// The definitions are read from the management endpoint, also for the batch targets
+	data, err := fetch(ctx, configmetrics.Target{
+		ResourceId: resourceId,
+		Variables:  target.Variables,
+		API: finopsdatatypes.API{
+			Path:        strings.TrimPrefix(resourceId, "/") + "/providers/Microsoft.Insights/metricDefinitions?api-version=" + opts.APIVersion,
+			Verb:        "GET",
+			EndpointRef: target.API.EndpointRef,
+		},
+		Timeout: target.Timeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error while listing the metric definitions of %s: %w", resourceId, err)
+	}
+
+	definitions := configmetrics.MetricDefinitions{}
+	if err := json.Unmarshal(data, &definitions); err != nil {
+		return nil, fmt.Errorf("error while decoding the metric definitions of %s: %w", resourceId, err)
+	}
+	log.Logger.Debug().Msgf("Discovered %d metrics for %s", len(definitions.Value), resourceId)
+
+	d.mu.Lock()
+	d.cache[key] = entry{definitions: definitions.Value, expires: time.Now().Add(opts.CacheTTL)}
+	d.mu.Unlock()
+	return definitions.Value, nil
+}
+
+// Selected returns whether a metric name matches one of the include patterns, or there are
+// none, and none of the exclude patterns. Patterns are case-insensitive globs, see utils.Glob:
+// "*" also matches "/", which appears in many metric names, e.g. "Disk Read Bytes/sec".
+// Invalid patterns, rejected by the configuration validation, match nothing.
+func Selected(name string, include, exclude []string) bool {
+	matches := func(patterns []string) bool {
+		for _, pattern := range patterns {
+			if glob, err := utils.Glob(pattern); err == nil && glob.MatchString(name) {
+				return true
+			}
+		}
+		return false
+	}
+	return (len(include) == 0 || matches(include)) && !matches(exclude)
+}
+
+// Aggregation returns the first preferred aggregation type the metric supports,
+// or its primary aggregation type.
+func Aggregation(def configmetrics.MetricDefinition, preferred []string) string {
+	for _, p := range preferred {
+		for _, supported := range def.SupportedAggregationTypes {
+			if strings.EqualFold(p, supported) {
+				return supported
+			}
+		}
+	}
+	return def.PrimaryAggregationType
+}
+
+// withQuery sets query parameters of a request path, keeping the other ones.
+func withQuery(p string, params map[string]string) (string, error) {
+	base, rawQuery, _ := strings.Cut(p, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", fmt.Errorf("invalid query in API path %s: %w", p, err)
+	}
+	for k, v := range params {
+		// Azure accepts the parameters in any case, e.g. metricNames
+		for existing := range query {
+			if strings.EqualFold(existing, k) {
+				query.Del(existing)
+			}
+		}
+		query.Set(k, v)
+	}
+	return base + "?" + query.Encode(), nil
+}
@@ -0,0 +1,203 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	configmetrics "github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/config"
+
+	finopsdatatypes "github.com/krateoplatformops/finops-data-types/api/v1"
+)
+
+func TestSelected(t *testing.T) {
+	tests := []struct {
+		name             string
+		metric           string
+		include, exclude []string
+		want             bool
+	}{
+		{name: "no patterns", metric: "Percentage CPU", want: true},
+		{name: "exact", metric: "Percentage CPU", include: []string{"Percentage CPU"}, want: true},
+		{name: "case insensitive", metric: "Percentage CPU", include: []string{"percentage cpu"}, want: true},
+		{name: "star matches slash", metric: "Disk Read Bytes/sec", include: []string{"Disk*"}, want: true},
+		{name: "star inside name with slash", metric: "Disk Read Bytes/sec", include: []string{"*Bytes*sec"}, want: true},
+		{name: "question mark", metric: "Disk Read Bytes/sec", include: []string{"Disk Read Bytes?sec"}, want: true},
+		{name: "anchored", metric: "Percentage CPU", include: []string{"CPU"}, want: false},
+		{name: "character class", metric: "Network In", include: []string{"Network [IO]*"}, want: true},
+		{name: "negated character class", metric: "Network In", include: []string{"Network [!I]*"}, want: false},
+		{name: "regexp metacharacters are literal", metric: "Data (GB)", include: []string{"Data (GB)"}, want: true},
+		{name: "dot is literal", metric: "Percentage CPU", include: []string{"Percentage.CPU"}, want: false},
+		{name: "not included", metric: "Network In", include: []string{"Disk*"}, want: false},
+		{name: "excluded", metric: "Disk Read Bytes/sec", include: []string{"Disk*"}, exclude: []string{"*/sec"}, want: false},
+		{name: "excluded without include", metric: "Disk Read Bytes/sec", exclude: []string{"disk*"}, want: false},
+		{name: "invalid pattern matches nothing", metric: "Disk[", include: []string{"Disk["}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Selected(tt.metric, tt.include, tt.exclude); got != tt.want {
+				t.Errorf("Selected(%q, %q, %q) = %v, want %v", tt.metric, tt.include, tt.exclude, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAggregation(t *testing.T) {
+	def := configmetrics.MetricDefinition{
+		PrimaryAggregationType:    "Average",
+		SupportedAggregationTypes: []string{"None", "Average", "Minimum", "Maximum", "Total", "Count"},
+	}
+
+	tests := []struct {
+		name      string
+		preferred []string
+		want      string
+	}{
+		{name: "no preference", want: "Average"},
+		{name: "first supported", preferred: []string{"Total", "Maximum"}, want: "Total"},
+		{name: "skips unsupported", preferred: []string{"Percentile", "Maximum"}, want: "Maximum"},
+		{name: "case insensitive, returns the Azure spelling", preferred: []string{"minimum"}, want: "Minimum"},
+		{name: "none supported", preferred: []string{"Percentile"}, want: "Average"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Aggregation(def, tt.preferred); got != tt.want {
+				t.Errorf("Aggregation(%q) = %q, want %q", tt.preferred, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithQuery(t *testing.T) {
+	params := map[string]string{"metricnames": "a,b", "aggregation": "Total"}
+
+	tests := []struct {
+		name string
+		path string
+		want url.Values
+	}{
+		{
+			name: "no query",
+			path: "subscriptions/s/providers/Microsoft.Insights/metrics",
+			want: url.Values{"metricnames": {"a,b"}, "aggregation": {"Total"}},
+		},
+		{
+			name: "keeps the other parameters",
+			path: "subscriptions/s/providers/Microsoft.Insights/metrics?api-version=2023-10-01&timespan=PT1H&interval=PT1M",
+			want: url.Values{"api-version": {"2023-10-01"}, "timespan": {"PT1H"}, "interval": {"PT1M"}, "metricnames": {"a,b"}, "aggregation": {"Total"}},
+		},
+		{
+			name: "replaces parameters in any case",
+			path: "metrics?api-version=2023-10-01&metricNames=Percentage%20CPU&Aggregation=Average",
+			want: url.Values{"api-version": {"2023-10-01"}, "metricnames": {"a,b"}, "aggregation": {"Total"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := withQuery(tt.path, params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			base, rawQuery, _ := strings.Cut(got, "?")
+			if wantBase, _, _ := strings.Cut(tt.path, "?"); base != wantBase {
+				t.Errorf("path = %q, want %q", base, wantBase)
+			}
+			query, err := url.ParseQuery(rawQuery)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(query, tt.want) {
+				t.Errorf("query = %v, want %v", query, tt.want)
+			}
+		})
+	}
+
+	if _, err := withQuery("metrics?a=%zz", params); err == nil {
+		t.Error("expected an error for an invalid query")
+	}
+}
+
+func TestTargetsChunks(t *testing.T) {
+	definitions := configmetrics.MetricDefinitions{}
+	for i := range 45 {
+		definitions.Value = append(definitions.Value, configmetrics.MetricDefinition{
+			Name:                      configmetrics.Name{Value: fmt.Sprintf("Metric %02d/sec", i)},
+			PrimaryAggregationType:    "Average",
+			SupportedAggregationTypes: []string{"Average", "Total"},
+		})
+	}
+	definitions.Value = append(definitions.Value, configmetrics.MetricDefinition{
+		Name:                   configmetrics.Name{Value: "Only Count"},
+		PrimaryAggregationType: "Count",
+	})
+	data, err := json.Marshal(definitions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := 0
+	fetch := func(ctx context.Context, target configmetrics.Target) ([]byte, error) {
+		calls++
+		return data, nil
+	}
+
+	opts := configmetrics.Discovery{Aggregations: []string{"Total"}, APIVersion: "2018-01-01", CacheTTL: configmetrics.DefaultDiscoveryCacheTTL}
+	target := configmetrics.Target{
+		ResourceId: "/subscriptions/s/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm",
+		API: finopsdatatypes.API{
+			Path: "subscriptions/s/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm/providers/Microsoft.Insights/metrics?api-version=2023-10-01",
+			Verb: "GET",
+		},
+	}
+
+	d := NewDiscoverer()
+	targets, err := d.Targets(context.Background(), opts, target, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type request struct {
+		aggregation string
+		metrics     int
+	}
+	got := []request{}
+	seen := map[string]bool{}
+	for _, target := range targets {
+		_, rawQuery, _ := strings.Cut(target.API.Path, "?")
+		query, err := url.ParseQuery(rawQuery)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if query.Get("api-version") != "2023-10-01" {
+			t.Errorf("api-version = %q, want it kept", query.Get("api-version"))
+		}
+		names := strings.Split(query.Get("metricnames"), ",")
+		for _, name := range names {
+			if seen[name] {
+				t.Errorf("metric %q requested twice", name)
+			}
+			seen[name] = true
+		}
+		got = append(got, request{aggregation: query.Get("aggregation"), metrics: len(names)})
+	}
+
+	want := []request{{"Count", 1}, {"Total", 20}, {"Total", 20}, {"Total", 5}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("requests = %v, want %v", got, want)
+	}
+
+	// The definitions are cached
+	if _, err := d.Targets(context.Background(), opts, target, fetch); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Errorf("metricDefinitions calls = %d, want 1", calls)
+	}
+}
@@ -0,0 +1,139 @@
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Check is the result of a single readiness check.
+type Check struct {
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+// Status is the body of the /healthz and /readyz responses.
+type Status struct {
+	Status string           `json:"status"`
+	Checks map[string]Check `json:"checks,omitempty"`
+}
+
+// Checker tracks the state the readiness of the exporter depends on: a parsed
+// configuration, a resolved endpoint and a recent successful Azure scrape.
+type Checker struct {
+	mu              sync.RWMutex
+	configErr       error
+	configLoaded    bool
+	endpointErr     error
+	endpointLoaded  bool
+	lastScrape      time.Time
+	pollingInterval time.Duration
+	maxIntervals    int
+}
+
+func NewChecker() *Checker {
+	return &Checker{}
+}
+
+// ConfigLoaded records a valid configuration: the exporter is ready only if a scrape
+// succeeded within maxIntervals polling intervals, or regardless of the scrapes when
+// maxIntervals is zero.
+func (c *Checker) ConfigLoaded(pollingInterval time.Duration, maxIntervals int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.configLoaded, c.configErr = true, nil
+	c.pollingInterval, c.maxIntervals = pollingInterval, maxIntervals
+}
+
+// ConfigFailed records that no valid configuration could be loaded.
+func (c *Checker) ConfigFailed(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.configLoaded, c.configErr = false, err
+}
+
+// EndpointResolved records the result of the last endpoint resolution.
+func (c *Checker) EndpointResolved(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.endpointLoaded, c.endpointErr = err == nil, err
+}
+
+// ScrapeSucceeded records a scrape in which at least one Azure API call succeeded.
+func (c *Checker) ScrapeSucceeded() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastScrape = time.Now()
+}
+
+// Ready returns the result of every readiness check and whether all of them passed.
+func (c *Checker) Ready() (map[string]Check, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	checks := map[string]Check{
+		"config":   {OK: c.configLoaded},
+		"endpoint": {OK: c.endpointLoaded},
+		"scrape":   {},
+	}
+
+	switch {
+	case c.configErr != nil:
+		checks["config"] = Check{Message: c.configErr.Error()}
+	case !c.configLoaded:
+		checks["config"] = Check{Message: "configuration not loaded yet"}
+	}
+
+	switch {
+	case c.endpointErr != nil:
+		checks["endpoint"] = Check{Message: c.endpointErr.Error()}
+	case !c.endpointLoaded:
+		checks["endpoint"] = Check{Message: "endpoint not resolved yet"}
+	}
+
+	maxAge := c.pollingInterval * time.Duration(c.maxIntervals)
+	switch {
+	case c.configLoaded && c.maxIntervals <= 0:
+		checks["scrape"] = Check{OK: true, Message: "scrapes are not required"}
+	case c.lastScrape.IsZero():
+		checks["scrape"] = Check{Message: "no successful Azure scrape yet"}
+	case c.configLoaded && time.Since(c.lastScrape) > maxAge:
+		checks["scrape"] = Check{Message: fmt.Sprintf("last successful Azure scrape %s ago, more than %d polling intervals", time.Since(c.lastScrape).Round(time.Second), c.maxIntervals)}
+	default:
+		checks["scrape"] = Check{OK: true}
+	}
+
+	ready := true
+	for _, check := range checks {
+		ready = ready && check.OK
+	}
+	return checks, ready
+}
+
+// LivenessHandler answers as long as the process is able to serve requests.
+func LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, http.StatusOK, Status{Status: "ok"})
+	})
+}
+
+// ReadinessHandler answers 200 when all the readiness checks pass, 503 otherwise,
+// with the result of each check in the body.
+func (c *Checker) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		checks, ready := c.Ready()
+		if !ready {
+			writeStatus(w, http.StatusServiceUnavailable, Status{Status: "failed", Checks: checks})
+			return
+		}
+		writeStatus(w, http.StatusOK, Status{Status: "ok", Checks: checks})
+	})
+}
+
+func writeStatus(w http.ResponseWriter, code int, status Status) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
@@ -20,9 +20,21 @@ type ResolveOptions struct {
 	API        *finopsdatatypes.API
 	AuthNS     string
 	Username   string
+	// Secrets, when set, is used to read the endpoint Secret instead of
+	// a client built from RESTConfig, e.g. a secrets.Cache
+	Secrets secrets.Getter
 }
 
 func Resolve(ctx context.Context, opts ResolveOptions) (*httpcall.Endpoint, error) {
+	if opts.Secrets != nil {
+		res := &resolver{
+			secrets:  opts.Secrets,
+			authNS:   opts.AuthNS,
+			username: opts.Username,
+		}
+		return res.Do(ctx, opts.API.EndpointRef)
+	}
+
 	state := opts.RESTConfig.Impersonate
 	defer func() {
 		opts.RESTConfig.Impersonate = state
@@ -48,15 +60,19 @@ func endpointResolver(rc *rest.Config, authNS, username string) (*resolver, erro
 		return nil, err
 	}
 
+	getter := secrets.GetterFunc(func(ctx context.Context, namespace, name string) (*v1.Secret, error) {
+		return cli.Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	})
+
 	return &resolver{
-		cli:      cli,
+		secrets:  getter,
 		authNS:   authNS,
 		username: username,
 	}, nil
 }
 
 type resolver struct {
-	cli      *secrets.Client
+	secrets  secrets.Getter
 	authNS   string
 	username string
 }
@@ -87,7 +103,7 @@ func (er *resolver) Do(ctx context.Context, ref *finopsdatatypes.ObjectRef) (*ht
 	}
 
 	if !isInternal {
-		sec, err = er.cli.Namespace(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+		sec, err = er.secrets.Get(ctx, ref.Namespace, ref.Name)
 		if err != nil {
 			return res, err
 		}
@@ -115,6 +131,34 @@ func (er *resolver) Do(ctx context.Context, ref *finopsdatatypes.ObjectRef) (*ht
 		res.Password = string(v)
 	}
 
+	if v, ok := sec.Data["tenant-id"]; ok {
+		res.TenantID = string(v)
+	}
+
+	if v, ok := sec.Data["client-id"]; ok {
+		res.ClientID = string(v)
+	}
+
+	if v, ok := sec.Data["client-secret"]; ok {
+		res.ClientSecret = string(v)
+	}
+
+	if v, ok := sec.Data["scope"]; ok {
+		res.Scope = string(v)
+	}
+
+	if v, ok := sec.Data["authority-host"]; ok {
+		res.AuthorityHost = string(v)
+	}
+
+	if v, ok := sec.Data["auth-type"]; ok {
+		res.AuthType = string(v)
+	}
+
+	if v, ok := sec.Data["federated-token-file"]; ok {
+		res.FederatedTokenFile = string(v)
+	}
+
 	if v, ok := sec.Data["certificate-authority-data"]; ok {
 		res.CertificateAuthorityData = string(v)
 	}
@@ -0,0 +1,118 @@
+package httpcall
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"time"
+)
+
+// clientAssertionLifetime is the validity of the signed client assertions
+const clientAssertionLifetime = 10 * time.Minute
+
+// parseClientCertificate decodes the base64 PEM client certificate and RSA key of the endpoint.
+func parseClientCertificate(authn *Endpoint) (*x509.Certificate, *rsa.PrivateKey, error) {
+	certData, err := base64.StdEncoding.DecodeString(authn.ClientCertificateData)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to decode client certificate data")
+	}
+
+	keyData, err := base64.StdEncoding.DecodeString(authn.ClientKeyData)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to decode client key data")
+	}
+
+	var cert *x509.Certificate
+	for block, rest := pem.Decode(certData); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type == "CERTIFICATE" {
+			cert, err = x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, nil, fmt.Errorf("unable to parse client certificate: %w", err)
+			}
+			break
+		}
+	}
+	if cert == nil {
+		return nil, nil, fmt.Errorf("no certificate found in client certificate data")
+	}
+
+	var key *rsa.PrivateKey
+	for block, rest := pem.Decode(keyData); block != nil; block, rest = pem.Decode(rest) {
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "PRIVATE KEY":
+			var parsed any
+			parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+			if rsaKey, ok := parsed.(*rsa.PrivateKey); ok {
+				key = rsaKey
+			} else if err == nil {
+				err = fmt.Errorf("only RSA keys are supported")
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to parse client key: %w", err)
+		}
+		break
+	}
+	if key == nil {
+		return nil, nil, fmt.Errorf("no private key found in client key data")
+	}
+
+	return cert, key, nil
+}
+
+// clientAssertion returns a JWT, signed with the key, asserting the identity of the
+// client to the Azure AD token endpoint.
+func clientAssertion(cert *x509.Certificate, key *rsa.PrivateKey, clientID string, audience string) (string, error) {
+	thumbprint := sha1.Sum(cert.Raw)
+	header := map[string]string{
+		"alg": "RS256",
+		"typ": "JWT",
+		"x5t": base64.RawURLEncoding.EncodeToString(thumbprint[:]),
+	}
+
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := map[string]any{
+		"aud": audience,
+		"iss": clientID,
+		"sub": clientID,
+		"jti": hex.EncodeToString(jti),
+		"nbf": now.Unix(),
+		"iat": now.Unix(),
+		"exp": now.Add(clientAssertionLifetime).Unix(),
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("unable to sign client assertion: %w", err)
+	}
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -0,0 +1,195 @@
+package httpcall
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// selfSigned returns a self-signed certificate for key, PEM encoded.
+func selfSigned(t *testing.T, key crypto.Signer) ([]byte, *x509.Certificate) {
+	t.Helper()
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "exporter"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), cert
+}
+
+func pkcs8PEM(t *testing.T, key any) []byte {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+// decodeSegment decodes a base64url JSON segment of a JWT.
+func decodeSegment(t *testing.T, segment string, v any) {
+	t.Helper()
+
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		t.Fatalf("invalid JWT segment %q: %v", segment, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatalf("invalid JWT segment %s: %v", data, err)
+	}
+}
+
+func TestClientCertificate(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, cert := selfSigned(t, key)
+
+	tests := []struct {
+		name   string
+		keyPEM []byte
+	}{
+		{name: "PKCS#1 key", keyPEM: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})},
+		{name: "PKCS#8 key", keyPEM: pkcs8PEM(t, key)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := &tokenServer{expiresIn: 3600}
+			server := httptest.NewServer(ts)
+			defer server.Close()
+
+			authn := &Endpoint{
+				AuthType:              AuthTypeClientCertificate,
+				TenantID:              "tenant",
+				ClientID:              "client-" + t.Name(),
+				AuthorityHost:         server.URL,
+				ClientCertificateData: base64.StdEncoding.EncodeToString(certPEM),
+				// The key may follow other blocks, e.g. in a combined PEM file
+				ClientKeyData: base64.StdEncoding.EncodeToString(append(certPEM, tt.keyPEM...)),
+			}
+			if got := get(t, authn); got != "Bearer token-1" {
+				t.Fatalf("Authorization = %q, want Bearer token-1", got)
+			}
+
+			forms := ts.requests()
+			if len(forms) != 1 {
+				t.Fatalf("token endpoint got %d requests, want 1", len(forms))
+			}
+			form := forms[0]
+			if form["client_assertion_type"] != clientAssertionType || form["client_id"] != authn.ClientID {
+				t.Errorf("form = %v, want a client assertion of %s", form, authn.ClientID)
+			}
+
+			segments := strings.Split(form["client_assertion"], ".")
+			if len(segments) != 3 {
+				t.Fatalf("client_assertion = %q, want a JWT", form["client_assertion"])
+			}
+
+			signature, err := base64.RawURLEncoding.DecodeString(segments[2])
+			if err != nil {
+				t.Fatal(err)
+			}
+			digest := sha256.Sum256([]byte(segments[0] + "." + segments[1]))
+			if err := rsa.VerifyPKCS1v15(cert.PublicKey.(*rsa.PublicKey), crypto.SHA256, digest[:], signature); err != nil {
+				t.Errorf("client_assertion signature does not verify with the certificate: %v", err)
+			}
+
+			header := map[string]string{}
+			decodeSegment(t, segments[0], &header)
+			thumbprint := sha1.Sum(cert.Raw)
+			if header["alg"] != "RS256" || header["x5t"] != base64.RawURLEncoding.EncodeToString(thumbprint[:]) {
+				t.Errorf("header = %v, want RS256 and the SHA-1 thumbprint of the certificate", header)
+			}
+
+			claims := map[string]any{}
+			decodeSegment(t, segments[1], &claims)
+			if want := server.URL + "/tenant/oauth2/v2.0/token"; claims["aud"] != want {
+				t.Errorf("aud = %v, want %s", claims["aud"], want)
+			}
+			if claims["iss"] != authn.ClientID || claims["sub"] != authn.ClientID {
+				t.Errorf("iss = %v, sub = %v, want %s", claims["iss"], claims["sub"], authn.ClientID)
+			}
+			nbf, _ := claims["nbf"].(float64)
+			exp, _ := claims["exp"].(float64)
+			if time.Duration(exp-nbf)*time.Second != clientAssertionLifetime {
+				t.Errorf("nbf = %v, exp = %v, want a lifetime of %s", nbf, exp, clientAssertionLifetime)
+			}
+		})
+	}
+}
+
+func TestParseClientCertificate(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaCertPEM, _ := selfSigned(t, rsaKey)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecCertPEM, _ := selfSigned(t, ecKey)
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		cert    []byte
+		key     []byte
+		wantErr string
+	}{
+		{name: "RSA key", cert: rsaCertPEM, key: pkcs8PEM(t, rsaKey)},
+		{name: "PKCS#8 ECDSA key", cert: ecCertPEM, key: pkcs8PEM(t, ecKey), wantErr: "only RSA keys are supported"},
+		{name: "SEC 1 ECDSA key", cert: ecCertPEM, key: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER}), wantErr: "no private key found"},
+		{name: "no certificate", cert: pkcs8PEM(t, rsaKey), key: pkcs8PEM(t, rsaKey), wantErr: "no certificate found"},
+		{name: "invalid key", cert: rsaCertPEM, key: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("invalid")}), wantErr: "unable to parse client key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, key, err := parseClientCertificate(&Endpoint{
+				ClientCertificateData: base64.StdEncoding.EncodeToString(tt.cert),
+				ClientKeyData:         base64.StdEncoding.EncodeToString(tt.key),
+			})
+			if tt.wantErr == "" {
+				if err != nil || key == nil {
+					t.Fatalf("parseClientCertificate() = %v, %v, want the RSA key", key, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseClientCertificate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
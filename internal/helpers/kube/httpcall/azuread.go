@@ -0,0 +1,264 @@
+package httpcall
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultAuthorityHost = "https://login.microsoftonline.com"
+	DefaultScope         = "https://management.azure.com/.default"
+
+	// clientAssertionType is the client_assertion_type of the JWT client assertions
+	clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+	// tokenExpiryDelta is how long before its expiration a cached token is refreshed
+	tokenExpiryDelta = 5 * time.Minute
+)
+
+var (
+	tokenSourcesMu sync.Mutex
+	tokenSources   = map[string]*tokenSource{}
+)
+
+type accessToken struct {
+	value     string
+	expiresOn time.Time
+}
+
+// tokenSource returns Azure AD access tokens, fetching a new one only when
+// the cached token is about to expire.
+type tokenSource struct {
+	mu       sync.Mutex
+	authType string
+	token    *accessToken
+	fetch    func(ctx context.Context) (*accessToken, error)
+}
+
+func (ts *tokenSource) Token(ctx context.Context) (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.token != nil && time.Now().Add(tokenExpiryDelta).Before(ts.token.expiresOn) {
+		return ts.token.value, nil
+	}
+
+	token, err := ts.fetch(ctx)
+	notifyTokenRefresh(ts.authType, err)
+	if err != nil {
+		return "", err
+	}
+	ts.token = token
+	return token.value, nil
+}
+
+// Invalidate drops the cached token, so that the next request fetches a new one.
+func (ts *tokenSource) Invalidate() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.token = nil
+}
+
+// cachedTokenSource returns the token source of the authentication type for the given key, creating
+// it with fetch the first time. Tokens are cached across HTTP clients, which are created for every request.
+func cachedTokenSource(authType string, key string, fetch func(ctx context.Context) (*accessToken, error)) *tokenSource {
+	tokenSourcesMu.Lock()
+	defer tokenSourcesMu.Unlock()
+
+	key = authType + "|" + key
+	if ts, ok := tokenSources[key]; ok {
+		return ts
+	}
+	ts := &tokenSource{authType: authType, fetch: fetch}
+	tokenSources[key] = ts
+	return ts
+}
+
+func clientCredentialsTokenSource(authn *Endpoint, rt http.RoundTripper) *tokenSource {
+	secretHash := sha256.Sum256([]byte(authn.ClientSecret))
+	key := strings.Join([]string{authorityHost(authn), authn.TenantID, authn.ClientID, scope(authn), hex.EncodeToString(secretHash[:])}, "|")
+
+	return cachedTokenSource(AuthTypeClientCredentials, key, func(ctx context.Context) (*accessToken, error) {
+		return requestToken(ctx, rt, authn, url.Values{
+			"grant_type":    {"client_credentials"},
+			"client_id":     {authn.ClientID},
+			"client_secret": {authn.ClientSecret},
+			"scope":         {scope(authn)},
+		})
+	})
+}
+
+// workloadIdentityTokenSource exchanges the projected service account token for an Azure AD
+// access token. The tenant, the client, the token file and the authority host not set in the
+// endpoint are taken from the environment injected by the Azure Workload Identity webhook.
+func workloadIdentityTokenSource(authn *Endpoint, rt http.RoundTripper) *tokenSource {
+	wi := *authn
+	if wi.TenantID == "" {
+		wi.TenantID = os.Getenv("AZURE_TENANT_ID")
+	}
+	if wi.ClientID == "" {
+		wi.ClientID = os.Getenv("AZURE_CLIENT_ID")
+	}
+	if wi.FederatedTokenFile == "" {
+		wi.FederatedTokenFile = os.Getenv("AZURE_FEDERATED_TOKEN_FILE")
+	}
+	if wi.AuthorityHost == "" {
+		wi.AuthorityHost = os.Getenv("AZURE_AUTHORITY_HOST")
+	}
+	key := strings.Join([]string{authorityHost(&wi), wi.TenantID, wi.ClientID, scope(&wi), wi.FederatedTokenFile}, "|")
+
+	return cachedTokenSource(AuthTypeWorkloadIdentity, key, func(ctx context.Context) (*accessToken, error) {
+		if wi.TenantID == "" || wi.ClientID == "" || wi.FederatedTokenFile == "" {
+			return nil, fmt.Errorf("workload identity requires tenant-id, client-id and the federated token file")
+		}
+
+		// The token file is rotated by the kubelet, hence it is read again for every exchange
+		assertion, err := os.ReadFile(wi.FederatedTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read federated token file: %w", err)
+		}
+
+		return requestToken(ctx, rt, &wi, url.Values{
+			"grant_type":            {"client_credentials"},
+			"client_id":             {wi.ClientID},
+			"client_assertion_type": {clientAssertionType},
+			"client_assertion":      {strings.TrimSpace(string(assertion))},
+			"scope":                 {scope(&wi)},
+		})
+	})
+}
+
+// clientCertificateTokenSource signs a JWT client assertion with the client key, identifying the
+// certificate by its thumbprint, and exchanges it for an Azure AD access token.
+func clientCertificateTokenSource(authn *Endpoint, rt http.RoundTripper) (*tokenSource, error) {
+	if authn.TenantID == "" || authn.ClientID == "" {
+		return nil, fmt.Errorf("client certificate authentication requires tenant-id and client-id")
+	}
+
+	cert, key, err := parseClientCertificate(authn)
+	if err != nil {
+		return nil, err
+	}
+
+	certHash := sha256.Sum256(cert.Raw)
+	cacheKey := strings.Join([]string{authorityHost(authn), authn.TenantID, authn.ClientID, scope(authn), hex.EncodeToString(certHash[:])}, "|")
+
+	return cachedTokenSource(AuthTypeClientCertificate, cacheKey, func(ctx context.Context) (*accessToken, error) {
+		assertion, err := clientAssertion(cert, key, authn.ClientID, tokenURL(authn))
+		if err != nil {
+			return nil, err
+		}
+
+		return requestToken(ctx, rt, authn, url.Values{
+			"grant_type":            {"client_credentials"},
+			"client_id":             {authn.ClientID},
+			"client_assertion_type": {clientAssertionType},
+			"client_assertion":      {assertion},
+			"scope":                 {scope(authn)},
+		})
+	}), nil
+}
+
+// requestToken calls the Azure AD token endpoint of the tenant with the given form.
+func requestToken(ctx context.Context, rt http.RoundTripper, authn *Endpoint, form url.Values) (*accessToken, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL(authn), strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := &http.Client{Transport: rt}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to request Azure AD token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read Azure AD token response: %w", err)
+	}
+
+	res := struct {
+		AccessToken      string      `json:"access_token"`
+		ExpiresIn        json.Number `json:"expires_in"`
+		Error            string      `json:"error"`
+		ErrorDescription string      `json:"error_description"`
+	}{}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, fmt.Errorf("unable to decode Azure AD token response (status code %d): %w", resp.StatusCode, err)
+	}
+
+	if resp.StatusCode != http.StatusOK || res.AccessToken == "" {
+		err := fmt.Errorf("azure AD token request failed with status code %d: %s %s", resp.StatusCode, res.Error, res.ErrorDescription)
+		// Client errors, e.g. invalid_client or an unknown application, cannot be solved by retrying
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusRequestTimeout {
+			return nil, Permanent(err)
+		}
+		return nil, err
+	}
+
+	expiresIn, err := res.ExpiresIn.Int64()
+	if err != nil {
+		return nil, fmt.Errorf("invalid expires_in in Azure AD token response: %w", err)
+	}
+
+	return &accessToken{
+		value:     res.AccessToken,
+		expiresOn: time.Now().Add(time.Duration(expiresIn) * time.Second),
+	}, nil
+}
+
+func tokenURL(authn *Endpoint) string {
+	return fmt.Sprintf("%s/%s/oauth2/v2.0/token", strings.TrimSuffix(authorityHost(authn), "/"), url.PathEscape(authn.TenantID))
+}
+
+func authorityHost(authn *Endpoint) string {
+	if authn.AuthorityHost != "" {
+		return authn.AuthorityHost
+	}
+	return DefaultAuthorityHost
+}
+
+func scope(authn *Endpoint) string {
+	if authn.Scope != "" {
+		return authn.Scope
+	}
+	return DefaultScope
+}
+
+type azureADRoundTripper struct {
+	source *tokenSource
+	rt     http.RoundTripper
+}
+
+func (rt *azureADRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(req.Header.Get("Authorization")) != 0 {
+		return rt.rt.RoundTrip(req)
+	}
+
+	token, err := rt.source.Token(req.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	req = cloneRequest(req)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+
+	resp, err := rt.rt.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		// The token may have been revoked: the next request will fetch a new one
+		rt.source.Invalidate()
+	}
+	return resp, err
+}
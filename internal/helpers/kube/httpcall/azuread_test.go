@@ -0,0 +1,168 @@
+package httpcall
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// tokenServer is a fake Azure AD token endpoint, recording the forms it receives.
+type tokenServer struct {
+	mu        sync.Mutex
+	forms     []map[string]string
+	expiresIn int
+}
+
+func (s *tokenServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r.Method != http.MethodPost || r.URL.Path != "/tenant/oauth2/v2.0/token" {
+		http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+		return
+	}
+	form := map[string]string{}
+	for k := range r.PostForm {
+		form[k] = r.PostForm.Get(k)
+	}
+	s.forms = append(s.forms, form)
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"token_type":"Bearer","expires_in":%d,"access_token":"token-%d"}`, s.expiresIn, len(s.forms))
+}
+
+func (s *tokenServer) requests() []map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]map[string]string{}, s.forms...)
+}
+
+// get makes an authenticated request and returns the Authorization header the API received.
+func get(t *testing.T, authn *Endpoint) string {
+	t.Helper()
+
+	var authorization string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer api.Close()
+
+	client, err := HTTPClientForEndpoint(authn)
+	if err != nil {
+		t.Fatalf("HTTPClientForEndpoint() error = %v", err)
+	}
+	resp, err := client.Get(api.URL)
+	if err != nil {
+		t.Fatalf("request error = %v", err)
+	}
+	resp.Body.Close()
+	return authorization
+}
+
+func workloadIdentityEndpoint(t *testing.T, authorityHost string) *Endpoint {
+	t.Helper()
+
+	tokenFile := filepath.Join(t.TempDir(), "azure-identity-token")
+	if err := os.WriteFile(tokenFile, []byte("federated-1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return &Endpoint{
+		AuthType:           AuthTypeWorkloadIdentity,
+		TenantID:           "tenant",
+		ClientID:           "client-" + t.Name(),
+		AuthorityHost:      authorityHost,
+		FederatedTokenFile: tokenFile,
+	}
+}
+
+func TestWorkloadIdentity(t *testing.T) {
+	ts := &tokenServer{expiresIn: 3600}
+	server := httptest.NewServer(ts)
+	defer server.Close()
+
+	authn := workloadIdentityEndpoint(t, server.URL)
+	if got := get(t, authn); got != "Bearer token-1" {
+		t.Errorf("Authorization = %q, want Bearer token-1", got)
+	}
+
+	forms := ts.requests()
+	if len(forms) != 1 {
+		t.Fatalf("token endpoint got %d requests, want 1", len(forms))
+	}
+	want := map[string]string{
+		"grant_type":            "client_credentials",
+		"client_id":             authn.ClientID,
+		"client_assertion_type": clientAssertionType,
+		"client_assertion":      "federated-1",
+		"scope":                 DefaultScope,
+	}
+	for k, v := range want {
+		if forms[0][k] != v {
+			t.Errorf("form %s = %q, want %q", k, forms[0][k], v)
+		}
+	}
+
+	// The token is cached across the HTTP clients
+	if got := get(t, authn); got != "Bearer token-1" {
+		t.Errorf("Authorization = %q, want the cached Bearer token-1", got)
+	}
+	if n := len(ts.requests()); n != 1 {
+		t.Errorf("token endpoint got %d requests, want 1", n)
+	}
+}
+
+func TestWorkloadIdentityRefresh(t *testing.T) {
+	// A token expiring within tokenExpiryDelta is refreshed at the next request
+	ts := &tokenServer{expiresIn: int(tokenExpiryDelta.Seconds()) - 60}
+	server := httptest.NewServer(ts)
+	defer server.Close()
+
+	authn := workloadIdentityEndpoint(t, server.URL)
+	if got := get(t, authn); got != "Bearer token-1" {
+		t.Errorf("Authorization = %q, want Bearer token-1", got)
+	}
+
+	// The rotated federated token is read again for the refresh
+	if err := os.WriteFile(authn.FederatedTokenFile, []byte("federated-2"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got := get(t, authn); got != "Bearer token-2" {
+		t.Errorf("Authorization = %q, want the refreshed Bearer token-2", got)
+	}
+	forms := ts.requests()
+	if len(forms) != 2 || forms[1]["client_assertion"] != "federated-2" {
+		t.Errorf("token endpoint got %v, want a second request with the rotated assertion", forms)
+	}
+}
+
+func TestHTTPClientForEndpointAuthType(t *testing.T) {
+	tests := []struct {
+		name    string
+		authn   Endpoint
+		wantErr bool
+	}{
+		{name: "no auth type", authn: Endpoint{Token: "token"}},
+		{name: "unknown auth type", authn: Endpoint{AuthType: "workload-identiy", Token: "token"}, wantErr: true},
+		{name: "client certificate without certificate", authn: Endpoint{AuthType: AuthTypeClientCertificate, TenantID: "t", ClientID: "c"}, wantErr: true},
+		{name: "client certificate without tenant", authn: Endpoint{AuthType: AuthTypeClientCertificate, ClientID: "c", ClientCertificateData: "cert", ClientKeyData: "key"}, wantErr: true},
+		{name: "client credentials without secret", authn: Endpoint{AuthType: AuthTypeClientCredentials, TenantID: "t", ClientID: "c"}, wantErr: true},
+		{name: "client credentials", authn: Endpoint{AuthType: AuthTypeClientCredentials, TenantID: "t", ClientID: "c", ClientSecret: "s"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := HTTPClientForEndpoint(&tt.authn)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("HTTPClientForEndpoint() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
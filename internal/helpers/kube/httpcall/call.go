@@ -2,12 +2,15 @@ package httpcall
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	finopsdatatypes "github.com/krateoplatformops/finops-data-types/api/v1"
 	"github.com/rs/zerolog/log"
@@ -17,6 +20,25 @@ type Options struct {
 	API      *finopsdatatypes.API
 	Endpoint *Endpoint
 	DS       map[string]any
+	// Timeout bounds the whole request, including the read of the response body. Zero means no timeout
+	Timeout time.Duration
+	// URL, when set, is requested instead of the API path, e.g. to follow a next link. It must
+	// be on the host of the endpoint, so that the credentials are not sent anywhere else
+	URL string
+}
+
+// TimeoutError is returned when a request does not complete within its timeout.
+type TimeoutError struct {
+	Timeout time.Duration
+	Err     error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("request timed out after %s: %v", e.Timeout, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
 }
 
 func Do(ctx context.Context, client *http.Client, opts Options) (*http.Response, error) {
@@ -29,6 +51,16 @@ func Do(ctx context.Context, client *http.Client, opts Options) (*http.Response,
 	if err != nil {
 		return nil, err
 	}
+	if opts.URL != "" {
+		next, err := url.Parse(opts.URL)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.EqualFold(next.Host, u.Host) {
+			return nil, fmt.Errorf("refusing to request %s, which is not on %s", next.Redacted(), u.Host)
+		}
+		u = next
+	}
 
 	verb := opts.API.Verb
 
@@ -37,30 +69,82 @@ func Do(ctx context.Context, client *http.Client, opts Options) (*http.Response,
 		body = strings.NewReader(opts.API.Payload)
 	}
 
+	// The parent context is kept to tell its cancellation apart from the request timeout
+	reqCtx, cancel := ctx, context.CancelFunc(func() {})
+	if opts.Timeout > 0 {
+		reqCtx, cancel = context.WithTimeout(ctx, opts.Timeout)
+	}
+
 	log.Info().Msgf("Request URL: %s", u.String())
-	req, err := http.NewRequestWithContext(ctx, verb, u.String(), body)
+	req, err := http.NewRequestWithContext(reqCtx, verb, u.String(), body)
 	if err != nil {
+		cancel()
 		return nil, err
 	}
 
-	if len(opts.API.Headers) > 0 {
-		for _, el := range opts.API.Headers {
-			idx := strings.Index(el, ":")
-			if idx <= 0 {
-				continue
-			}
-			req.Header.Set(el[:idx], el[idx+1:])
-		}
+	for name, value := range ParseHeaders(opts.API.Headers) {
+		req.Header.Set(name, value)
 	}
 
+	start := time.Now()
 	resp, err := client.Do(req)
 	if err != nil {
+		cancel()
+		err = timeoutError(ctx, reqCtx, opts.Timeout, err)
+		observeRequest(start, err, 0)
 		return nil, err
 	}
+	observeRequest(start, nil, resp.StatusCode)
 
+	// The timeout keeps running while the body is read
+	resp.Body = &timeoutBody{ReadCloser: resp.Body, parent: ctx, ctx: reqCtx, cancel: cancel, timeout: opts.Timeout}
 	return resp, nil
 }
 
+// observeRequest reports the outcome and the latency of a request to the observer
+func observeRequest(start time.Time, err error, statusCode int) {
+	code := strconv.Itoa(statusCode)
+	if err != nil {
+		code = "error"
+		var timeoutErr *TimeoutError
+		if errors.As(err, &timeoutErr) {
+			code = "timeout"
+		}
+	}
+	notifyRequest(code, time.Since(start))
+}
+
+// timeoutError wraps err in a TimeoutError when it has been caused by the deadline of the
+// request context, derived from parent with the timeout. An error of the parent context,
+// e.g. an expired scrape deadline, is returned as is.
+func timeoutError(parent, ctx context.Context, timeout time.Duration, err error) error {
+	if timeout > 0 && parent.Err() == nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &TimeoutError{Timeout: timeout, Err: err}
+	}
+	return err
+}
+
+type timeoutBody struct {
+	io.ReadCloser
+	parent  context.Context
+	ctx     context.Context
+	cancel  context.CancelFunc
+	timeout time.Duration
+}
+
+func (b *timeoutBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		err = timeoutError(b.parent, b.ctx, b.timeout, err)
+	}
+	return n, err
+}
+
+func (b *timeoutBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
 // Determine whether the request `content-type` includes a
 // server-acceptable mime-type
 //
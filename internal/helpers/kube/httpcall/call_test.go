@@ -0,0 +1,90 @@
+package httpcall
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	finopsdatatypes "github.com/krateoplatformops/finops-data-types/api/v1"
+)
+
+// stalledServer answers after a partial body, when body is set, or not at all, until the
+// client goes away.
+func stalledServer(t *testing.T, body bool) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if body {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			io.WriteString(w, `{"value":[`)
+			w.(http.Flusher).Flush()
+		}
+		<-r.Context().Done()
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestDoTimeout(t *testing.T) {
+	tests := []struct {
+		name           string
+		timeout        time.Duration
+		parentDeadline time.Duration
+		body           bool
+		wantTimeout    bool
+	}{
+		{name: "request timeout", timeout: 50 * time.Millisecond, wantTimeout: true},
+		{name: "request timeout while reading the body", timeout: 50 * time.Millisecond, body: true, wantTimeout: true},
+		{name: "parent deadline", timeout: 10 * time.Second, parentDeadline: 50 * time.Millisecond},
+		{name: "parent deadline while reading the body", timeout: 10 * time.Second, parentDeadline: 50 * time.Millisecond, body: true},
+		{name: "parent deadline without timeout", parentDeadline: 50 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := stalledServer(t, tt.body)
+
+			ctx := context.Background()
+			if tt.parentDeadline > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.parentDeadline)
+				defer cancel()
+			}
+
+			resp, err := Do(ctx, http.DefaultClient, Options{
+				API:      &finopsdatatypes.API{Path: "metrics", Verb: http.MethodGet},
+				Endpoint: &Endpoint{ServerURL: server.URL},
+				Timeout:  tt.timeout,
+			})
+			if err == nil {
+				_, err = io.ReadAll(resp.Body)
+				resp.Body.Close()
+			}
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			var timeoutErr *TimeoutError
+			if got := errors.As(err, &timeoutErr); got != tt.wantTimeout {
+				t.Fatalf("errors.As(%v, *TimeoutError) = %v, want %v", err, got, tt.wantTimeout)
+			}
+			if tt.wantTimeout && timeoutErr.Timeout != tt.timeout {
+				t.Errorf("TimeoutError.Timeout = %s, want %s", timeoutErr.Timeout, tt.timeout)
+			}
+			if !tt.wantTimeout {
+				if !errors.Is(err, context.DeadlineExceeded) {
+					t.Errorf("error = %v, want the parent context.DeadlineExceeded", err)
+				}
+				if strings.Contains(err.Error(), "timed out after") {
+					t.Errorf("error = %q, want no request timeout in it", err)
+				}
+			}
+		})
+	}
+}
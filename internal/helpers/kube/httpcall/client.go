@@ -19,11 +19,37 @@ func HTTPClientForEndpoint(authn *Endpoint) (*http.Client, error) {
 		}
 	}
 
+	if err := authn.checkAuthType(); err != nil {
+		return nil, err
+	}
+
 	// Set authentication wrappers
 	switch {
 	case authn.HasBasicAuth() && authn.HasTokenAuth():
 		return nil, fmt.Errorf("username/password or bearer token may be set, but not both")
 
+	case authn.HasWorkloadIdentityAuth():
+		rt = &azureADRoundTripper{
+			source: workloadIdentityTokenSource(authn, rt),
+			rt:     rt,
+		}
+
+	case authn.HasClientCertificateAuth():
+		source, err := clientCertificateTokenSource(authn, rt)
+		if err != nil {
+			return nil, err
+		}
+		rt = &azureADRoundTripper{
+			source: source,
+			rt:     rt,
+		}
+
+	case authn.HasClientCredentialsAuth():
+		rt = &azureADRoundTripper{
+			source: clientCredentialsTokenSource(authn, rt),
+			rt:     rt,
+		}
+
 	case authn.HasTokenAuth():
 		rt = &bearerAuthRoundTripper{
 			bearer: authn.Token,
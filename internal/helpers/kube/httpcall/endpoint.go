@@ -1,5 +1,19 @@
 package httpcall
 
+import "fmt"
+
+const (
+	// AuthTypeClientCredentials authenticates with an Azure AD client secret,
+	// used whenever tenant-id, client-id and client-secret are set
+	AuthTypeClientCredentials = "client-credentials"
+	// AuthTypeWorkloadIdentity exchanges the projected service account token
+	// for an Azure AD access token (Azure Workload Identity)
+	AuthTypeWorkloadIdentity = "workload-identity"
+	// AuthTypeClientCertificate signs a JWT client assertion with the client certificate
+	// and key, and exchanges it for an Azure AD access token (certificate-based service principal)
+	AuthTypeClientCertificate = "client-certificate"
+)
+
 type Endpoint struct {
 	ServerURL                string
 	ProxyURL                 string
@@ -9,6 +23,13 @@ type Endpoint struct {
 	Token                    string
 	Username                 string
 	Password                 string
+	TenantID                 string
+	ClientID                 string
+	ClientSecret             string
+	Scope                    string
+	AuthorityHost            string
+	AuthType                 string
+	FederatedTokenFile       string
 	Insecure                 bool
 	Debug                    bool
 }
@@ -32,3 +53,38 @@ func (ep *Endpoint) HasTokenAuth() bool {
 func (ep *Endpoint) HasCertAuth() bool {
 	return len(ep.ClientCertificateData) != 0 && len(ep.ClientKeyData) != 0
 }
+
+// HasClientCredentialsAuth returns whether the configuration has Azure AD client credentials or not.
+func (ep *Endpoint) HasClientCredentialsAuth() bool {
+	return len(ep.TenantID) != 0 && len(ep.ClientID) != 0 && len(ep.ClientSecret) != 0
+}
+
+// HasWorkloadIdentityAuth returns whether the configuration uses Azure Workload Identity or not.
+func (ep *Endpoint) HasWorkloadIdentityAuth() bool {
+	return ep.AuthType == AuthTypeWorkloadIdentity
+}
+
+// HasClientCertificateAuth returns whether the configuration uses an Azure AD certificate-based service principal or not.
+func (ep *Endpoint) HasClientCertificateAuth() bool {
+	return ep.AuthType == AuthTypeClientCertificate && ep.HasCertAuth() && len(ep.TenantID) != 0 && len(ep.ClientID) != 0
+}
+
+// checkAuthType returns an error when the authentication type is set but the endpoint
+// lacks what it requires, so that the requests are never sent without authentication.
+func (ep *Endpoint) checkAuthType() error {
+	switch ep.AuthType {
+	case "", AuthTypeWorkloadIdentity:
+		return nil
+	case AuthTypeClientCertificate:
+		if !ep.HasClientCertificateAuth() {
+			return fmt.Errorf("auth-type %s requires tenant-id, client-id and the client certificate and key data", ep.AuthType)
+		}
+	case AuthTypeClientCredentials:
+		if !ep.HasClientCredentialsAuth() {
+			return fmt.Errorf("auth-type %s requires tenant-id, client-id and client-secret", ep.AuthType)
+		}
+	default:
+		return fmt.Errorf("unknown auth-type %q, it must be one of %s, %s or %s", ep.AuthType, AuthTypeClientCredentials, AuthTypeWorkloadIdentity, AuthTypeClientCertificate)
+	}
+	return nil
+}
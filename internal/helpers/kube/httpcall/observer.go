@@ -0,0 +1,34 @@
+package httpcall
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Observer is notified of the outcome of the requests and of the Azure AD token
+// refreshes, e.g. to export them as metrics. Both functions are optional.
+type Observer struct {
+	// Request receives the status code of every request, or "error" or "timeout", and its latency
+	Request func(code string, duration time.Duration)
+	// TokenRefresh receives the authentication type of every token refresh and its error, if any
+	TokenRefresh func(authType string, err error)
+}
+
+var observer atomic.Pointer[Observer]
+
+// SetObserver sets the observer of all the requests and token refreshes, nil to remove it.
+func SetObserver(o *Observer) {
+	observer.Store(o)
+}
+
+func notifyRequest(code string, duration time.Duration) {
+	if o := observer.Load(); o != nil && o.Request != nil {
+		o.Request(code, duration)
+	}
+}
+
+func notifyTokenRefresh(authType string, err error) {
+	if o := observer.Load(); o != nil && o.TokenRefresh != nil {
+		o.TokenRefresh(authType, err)
+	}
+}
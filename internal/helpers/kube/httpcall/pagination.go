@@ -0,0 +1,89 @@
+package httpcall
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// MaxPages bounds the pages followed for a single request, in case Azure keeps returning a next link
+const MaxPages = 100
+
+// nextLinkFields are the fields Azure uses for the URL of the next page, depending on the API
+var nextLinkFields = []string{"nextLink", "@odata.nextLink"}
+
+// NextLink returns the URL of the next page of a JSON response, or an empty string on the last page.
+func NextLink(data []byte) string {
+	page := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &page); err != nil {
+		return ""
+	}
+	for _, field := range nextLinkFields {
+		var link string
+		if err := json.Unmarshal(page[field], &link); err == nil && link != "" {
+			return link
+		}
+	}
+	return ""
+}
+
+// Paginate follows the next links of a JSON response, calling fetch for every further page,
+// and returns all the pages merged in a single response, so that it can be decoded at once.
+func Paginate(ctx context.Context, data []byte, fetch func(ctx context.Context, nextLink string) ([]byte, error)) ([]byte, error) {
+	link := NextLink(data)
+	if link == "" {
+		return data, nil
+	}
+
+	pages := [][]byte{data}
+	for ; link != ""; link = NextLink(pages[len(pages)-1]) {
+		if len(pages) >= MaxPages {
+			return nil, fmt.Errorf("the response has more than %d pages", MaxPages)
+		}
+		page, err := fetch(ctx, link)
+		if err != nil {
+			return nil, fmt.Errorf("error while reading page %d: %w", len(pages)+1, err)
+		}
+		pages = append(pages, page)
+	}
+	return MergePages(pages)
+}
+
+// MergePages merges the pages of a JSON response: the array fields, e.g. value, are
+// concatenated, the other ones are taken from the first page and the next links are removed.
+func MergePages(pages [][]byte) ([]byte, error) {
+	merged := map[string]json.RawMessage{}
+	arrays := map[string][]json.RawMessage{}
+	for i, data := range pages {
+		page := map[string]json.RawMessage{}
+		if err := json.Unmarshal(data, &page); err != nil {
+			return nil, fmt.Errorf("page %d is not a JSON object: %w", i+1, err)
+		}
+		for field, value := range page {
+			if !bytes.HasPrefix(bytes.TrimSpace(value), []byte("[")) {
+				if _, ok := merged[field]; !ok {
+					merged[field] = value
+				}
+				continue
+			}
+			items := []json.RawMessage{}
+			if err := json.Unmarshal(value, &items); err != nil {
+				return nil, fmt.Errorf("invalid field %s in page %d: %w", field, i+1, err)
+			}
+			arrays[field] = append(arrays[field], items...)
+		}
+	}
+
+	for field, items := range arrays {
+		value, err := json.Marshal(items)
+		if err != nil {
+			return nil, err
+		}
+		merged[field] = value
+	}
+	for _, field := range nextLinkFields {
+		delete(merged, field)
+	}
+	return json.Marshal(merged)
+}
@@ -0,0 +1,186 @@
+package httpcall
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	finopsdatatypes "github.com/krateoplatformops/finops-data-types/api/v1"
+)
+
+// pagedServer serves /page/{n} for n up to pages, each linking to the next one through
+// the given field. The last page has no link, unless pages is zero: then there is no end.
+func pagedServer(t *testing.T, field string, pages int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	calls := &atomic.Int32{}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		n, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/page/"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		page := map[string]any{
+			"cost":  fmt.Sprintf("page-%d", n),
+			"value": []int{2*n - 1, 2 * n},
+		}
+		if pages == 0 || n < pages {
+			page[field] = fmt.Sprintf("%s/page/%d?$skipToken=%d", server.URL, n+1, n)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(page)
+	}))
+	t.Cleanup(server.Close)
+	return server, calls
+}
+
+// fetchPages returns a Paginate fetch function requesting the next links through Do,
+// with the given server as endpoint.
+func fetchPages(serverURL string) func(ctx context.Context, nextLink string) ([]byte, error) {
+	return func(ctx context.Context, nextLink string) ([]byte, error) {
+		resp, err := Do(ctx, http.DefaultClient, Options{
+			API:      &finopsdatatypes.API{Path: "page/1", Verb: http.MethodGet},
+			Endpoint: &Endpoint{ServerURL: serverURL},
+			URL:      nextLink,
+		})
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		return io.ReadAll(resp.Body)
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	for _, field := range []string{"nextLink", "@odata.nextLink"} {
+		t.Run(field, func(t *testing.T) {
+			server, calls := pagedServer(t, field, 3)
+			fetch := fetchPages(server.URL)
+
+			first, err := fetch(context.Background(), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := Paginate(context.Background(), first, fetch)
+			if err != nil {
+				t.Fatalf("Paginate() error = %v", err)
+			}
+
+			got := map[string]any{}
+			if err := json.Unmarshal(data, &got); err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]any{
+				"cost":  "page-1",
+				"value": []any{1.0, 2.0, 3.0, 4.0, 5.0, 6.0},
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Paginate() = %v, want %v", got, want)
+			}
+			if calls.Load() != 3 {
+				t.Errorf("requests = %d, want 3", calls.Load())
+			}
+		})
+	}
+}
+
+func TestPaginateSinglePage(t *testing.T) {
+	data := []byte(`{"value":[1],"cost":"a"}`)
+	got, err := Paginate(context.Background(), data, func(ctx context.Context, nextLink string) ([]byte, error) {
+		t.Fatalf("unexpected request of %s", nextLink)
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(data) {
+		t.Errorf("Paginate() = %s, want the response unchanged", got)
+	}
+}
+
+func TestPaginateRefusesOtherHost(t *testing.T) {
+	other, otherCalls := pagedServer(t, "nextLink", 2)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"value":[1],"nextLink":%q}`, other.URL+"/page/2")
+	}))
+	defer api.Close()
+	fetch := fetchPages(api.URL)
+
+	first, err := fetch(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Paginate(context.Background(), first, fetch); err == nil || !strings.Contains(err.Error(), "refusing to request") {
+		t.Fatalf("Paginate() error = %v, want the next link refused", err)
+	}
+	if otherCalls.Load() != 0 {
+		t.Errorf("requests to the other host = %d, want 0", otherCalls.Load())
+	}
+}
+
+func TestPaginateMaxPages(t *testing.T) {
+	server, calls := pagedServer(t, "nextLink", 0)
+	fetch := fetchPages(server.URL)
+
+	first, err := fetch(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Paginate(context.Background(), first, fetch); err == nil {
+		t.Fatal("expected an error for an endless response")
+	}
+	if calls.Load() != MaxPages {
+		t.Errorf("requests = %d, want %d", calls.Load(), MaxPages)
+	}
+}
+
+func TestMergePages(t *testing.T) {
+	tests := []struct {
+		name    string
+		pages   []string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "concatenates the arrays and keeps the first scalars",
+			pages: []string{`{"value":[1,2],"total":10,"nextLink":"a"}`, `{"value":[3],"total":20}`},
+			want:  `{"total":10,"value":[1,2,3]}`,
+		},
+		{
+			name:  "array only in a later page",
+			pages: []string{`{"count":1,"@odata.nextLink":"a"}`, `{"value":[{"id":"x"}]}`},
+			want:  `{"count":1,"value":[{"id":"x"}]}`,
+		},
+		{
+			name:    "not an object",
+			pages:   []string{`{"value":[]}`, `[1]`},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pages := [][]byte{}
+			for _, page := range tt.pages {
+				pages = append(pages, []byte(page))
+			}
+			got, err := MergePages(pages)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MergePages() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && string(got) != tt.want {
+				t.Errorf("MergePages() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package httpcall
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// RetryPolicy describes how failed API calls are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of calls, including the first one
+	MaxAttempts int `yaml:"maxAttempts"`
+	// BaseBackoff is the wait before the first retry, doubled at every following one
+	BaseBackoff time.Duration `yaml:"baseBackoff"`
+	// MaxBackoff caps the exponential backoff and the Retry-After waits
+	MaxBackoff time.Duration `yaml:"maxBackoff"`
+	// Jitter is the fraction of the backoff randomly added or removed, up to 1 (0.2 when not set).
+	// A negative value disables the jitter
+	Jitter float64 `yaml:"jitter"`
+	// RetryableStatusCodes are the status codes worth retrying, any other failure is permanent
+	RetryableStatusCodes []int `yaml:"retryableStatusCodes"`
+}
+
+// StatusError is returned when the API answers with an unsuccessful status code.
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d: %s", e.StatusCode, e.Body)
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent wraps an error that retrying cannot solve, so that RetryPolicy.Do returns it immediately.
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// Default sets the default value of every field that has not been configured.
+func (p *RetryPolicy) Default() {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 5
+	}
+	if p.BaseBackoff <= 0 {
+		p.BaseBackoff = 1 * time.Second
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = 1 * time.Minute
+	}
+	if p.Jitter == 0 || p.Jitter > 1 {
+		p.Jitter = 0.2
+	}
+	if p.RetryableStatusCodes == nil {
+		p.RetryableStatusCodes = []int{
+			http.StatusRequestTimeout,
+			http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		}
+	}
+}
+
+// Retryable returns whether a call that failed with the status code is worth retrying.
+func (p *RetryPolicy) Retryable(statusCode int) bool {
+	for _, code := range p.RetryableStatusCodes {
+		if code == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// Backoff returns how long to wait before the given retry, starting from 1. The
+// Retry-After header of the failed response, when present, takes precedence, up to
+// MaxBackoff so that a long Retry-After does not block the caller for as long.
+func (p *RetryPolicy) Backoff(retry int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if wait, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+			return min(wait, p.MaxBackoff)
+		}
+		// Azure Resource Manager throttling: when the quota is exhausted, wait as long as allowed
+		if resp.StatusCode == http.StatusTooManyRequests && rateLimitExhausted(resp.Header) {
+			return p.MaxBackoff
+		}
+	}
+
+	backoff := float64(p.BaseBackoff) * math.Pow(2, float64(retry-1))
+	backoff = math.Min(backoff, float64(p.MaxBackoff))
+	backoff += backoff * max(p.Jitter, 0) * (2*rand.Float64() - 1)
+	return time.Duration(backoff)
+}
+
+// Do calls the function until it returns a successful response, a permanent failure or
+// the maximum number of attempts is reached. The function is called again for every
+// attempt, so that it can refresh the endpoint and the client. Errors without a response,
+// a *TimeoutError included, are always retried. Unsuccessful responses are returned
+// as a *StatusError, with their body consumed and closed.
+func (p *RetryPolicy) Do(ctx context.Context, call func(ctx context.Context) (*http.Response, error)) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := call(ctx)
+		if err == nil && resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return resp, nil
+		}
+
+		retryable := true
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			return nil, permanent.err
+		}
+		if err == nil {
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			err = &StatusError{StatusCode: resp.StatusCode, Body: string(body)}
+			retryable = p.Retryable(resp.StatusCode)
+			if resp.StatusCode == http.StatusTooManyRequests {
+				log.Logger.Warn().Msgf("Throttled by Azure, remaining quota: %s", rateLimitRemaining(resp.Header))
+			}
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !retryable {
+			return nil, err
+		}
+		if attempt >= p.MaxAttempts {
+			return nil, fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+
+		wait := p.Backoff(attempt, resp)
+		log.Logger.Warn().Err(err).Msgf("API call failed, retrying in %s (attempt %d of %d)", wait.Round(time.Millisecond), attempt+1, p.MaxAttempts)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// retryAfter parses a Retry-After header, either in seconds or as an HTTP date.
+func retryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0), true
+	}
+	return 0, false
+}
+
+// rateLimitExhausted returns whether any of the x-ms-ratelimit-remaining-* headers is zero.
+func rateLimitExhausted(header http.Header) bool {
+	for key, values := range header {
+		if !strings.HasPrefix(strings.ToLower(key), "x-ms-ratelimit-remaining-") || len(values) == 0 {
+			continue
+		}
+		if remaining, err := strconv.Atoi(values[0]); err == nil && remaining <= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimitRemaining formats the x-ms-ratelimit-remaining-* headers for logging.
+func rateLimitRemaining(header http.Header) string {
+	res := []string{}
+	for key, values := range header {
+		if strings.HasPrefix(strings.ToLower(key), "x-ms-ratelimit-remaining-") && len(values) > 0 {
+			res = append(res, strings.ToLower(key)+"="+values[0])
+		}
+	}
+	if len(res) == 0 {
+		return "unknown"
+	}
+	return strings.Join(res, ", ")
+}
@@ -0,0 +1,94 @@
+package httpcall
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	finopsdatatypes "github.com/krateoplatformops/finops-data-types/api/v1"
+)
+
+func TestBackoffCapsRetryAfter(t *testing.T) {
+	p := RetryPolicy{}
+	p.Default()
+
+	resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"3600"}}}
+	if got := p.Backoff(1, resp); got != p.MaxBackoff {
+		t.Errorf("Backoff() = %s, want MaxBackoff %s", got, p.MaxBackoff)
+	}
+
+	resp.Header.Set("Retry-After", "2")
+	if got := p.Backoff(1, resp); got != 2*time.Second {
+		t.Errorf("Backoff() = %s, want 2s", got)
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	tests := []struct {
+		name       string
+		jitter     float64
+		wantJitter float64
+	}{
+		{name: "not set", jitter: 0, wantJitter: 0.2},
+		{name: "set", jitter: 0.5, wantJitter: 0.5},
+		{name: "out of range", jitter: 2, wantJitter: 0.2},
+		{name: "disabled", jitter: -1, wantJitter: -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := RetryPolicy{Jitter: tt.jitter}
+			p.Default()
+			if p.Jitter != tt.wantJitter {
+				t.Fatalf("Jitter = %v, want %v", p.Jitter, tt.wantJitter)
+			}
+
+			// The second retry waits twice the base backoff, give or take the jitter
+			want := 2 * p.BaseBackoff
+			spread := time.Duration(float64(want) * max(p.Jitter, 0))
+			for i := 0; i < 100; i++ {
+				got := p.Backoff(2, nil)
+				if got < want-spread || got > want+spread {
+					t.Fatalf("Backoff() = %s, want %s ± %s", got, want, spread)
+				}
+			}
+		})
+	}
+}
+
+func TestDoDoesNotRetryTokenClientErrors(t *testing.T) {
+	var tokenRequests atomic.Int32
+	token := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error":"invalid_client","error_description":"AADSTS7000215: Invalid client secret provided."}`))
+	}))
+	defer token.Close()
+
+	authn := &Endpoint{
+		ServerURL:     "http://127.0.0.1:1",
+		TenantID:      "tenant",
+		ClientID:      "client-" + t.Name(),
+		ClientSecret:  "wrong",
+		AuthorityHost: token.URL,
+	}
+	p := RetryPolicy{MaxAttempts: 5, BaseBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+	_, err := p.Do(context.Background(), func(ctx context.Context) (*http.Response, error) {
+		client, err := HTTPClientForEndpoint(authn)
+		if err != nil {
+			return nil, Permanent(err)
+		}
+		return Do(ctx, client, Options{API: &finopsdatatypes.API{Verb: http.MethodGet}, Endpoint: authn})
+	})
+	if err == nil {
+		t.Fatal("Do() succeeded, want the token error")
+	}
+	if n := tokenRequests.Load(); n != 1 {
+		t.Errorf("token endpoint got %d requests, want 1", n)
+	}
+}
@@ -25,7 +25,9 @@ func tlsConfigFor(e *Endpoint) (http.RoundTripper, error) {
 		res.Proxy = http.ProxyURL(u)
 	}
 
-	if !e.HasCertAuth() {
+	// The client certificate of a service principal is used to sign
+	// the Azure AD client assertion, not for mTLS
+	if !e.HasCertAuth() || e.HasClientCertificateAuth() {
 		if e.Insecure {
 			res.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
 		}
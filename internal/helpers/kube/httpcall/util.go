@@ -1,6 +1,9 @@
 package httpcall
 
-import "net/http"
+import (
+	"net/http"
+	"strings"
+)
 
 // cloneRequest creates a shallow copy of the request along with a deep copy of the Headers.
 func cloneRequest(req *http.Request) *http.Request {
@@ -25,3 +28,16 @@ func cloneHeader(in http.Header) http.Header {
 	}
 	return out
 }
+
+// ParseHeaders parses headers in the "Name: value" format, skipping the ones without a name.
+func ParseHeaders(headers []string) map[string]string {
+	res := map[string]string{}
+	for _, el := range headers {
+		idx := strings.Index(el, ":")
+		if idx <= 0 {
+			continue
+		}
+		res[el[:idx]] = strings.TrimSpace(el[idx+1:])
+	}
+	return res
+}
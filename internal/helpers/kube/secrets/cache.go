@@ -0,0 +1,158 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// syncTimeout is how long Get waits for a new watch to be ready before falling back to a direct GET
+	syncTimeout = 10 * time.Second
+)
+
+// Getter returns a Secret by namespace and name.
+type Getter interface {
+	Get(ctx context.Context, namespace, name string) (*corev1.Secret, error)
+}
+
+// GetterFunc adapts a function to the Getter interface.
+type GetterFunc func(ctx context.Context, namespace, name string) (*corev1.Secret, error)
+
+func (f GetterFunc) Get(ctx context.Context, namespace, name string) (*corev1.Secret, error) {
+	return f(ctx, namespace, name)
+}
+
+// Cache serves Secrets from memory, watching each requested Secret for changes.
+// When the exporter is not allowed to list and watch a Secret, it falls back
+// to a direct GET for every request.
+type Cache struct {
+	cs kubernetes.Interface
+
+	mu      sync.Mutex
+	watches map[types.NamespacedName]*secretWatch
+	stopped bool
+}
+
+var _ Getter = (*Cache)(nil)
+
+type secretWatch struct {
+	informer  cache.SharedIndexInformer
+	stop      chan struct{}
+	stopOnce  sync.Once
+	forbidden atomic.Bool
+}
+
+// close stops the informer. Both Cache.Stop and the watch error handler may call it.
+func (w *secretWatch) close() {
+	w.stopOnce.Do(func() { close(w.stop) })
+}
+
+func NewCache(cs kubernetes.Interface) *Cache {
+	return &Cache{
+		cs:      cs,
+		watches: map[types.NamespacedName]*secretWatch{},
+	}
+}
+
+func (c *Cache) Get(ctx context.Context, namespace, name string) (*corev1.Secret, error) {
+	w, err := c.watch(namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = wait.PollUntilContextTimeout(ctx, 100*time.Millisecond, syncTimeout, true, func(context.Context) (bool, error) {
+		return w.forbidden.Load() || w.informer.HasSynced(), nil
+	})
+	if err != nil || w.forbidden.Load() {
+		return c.cs.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	}
+
+	obj, exists, err := w.informer.GetStore().GetByKey(namespace + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, apierrors.NewNotFound(corev1.Resource("secrets"), name)
+	}
+
+	sec, ok := obj.(*corev1.Secret)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object of type %T in secrets cache", obj)
+	}
+	return sec.DeepCopy(), nil
+}
+
+// Stop stops all the watches.
+func (c *Cache) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stopped = true
+	for key, w := range c.watches {
+		w.close()
+		delete(c.watches, key)
+	}
+}
+
+// watch returns the watch of the Secret, starting it the first time.
+func (c *Cache) watch(namespace, name string) (*secretWatch, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stopped {
+		return nil, fmt.Errorf("secrets cache stopped")
+	}
+
+	key := types.NamespacedName{Namespace: namespace, Name: name}
+	if w, ok := c.watches[key]; ok {
+		return w, nil
+	}
+
+	selector := fields.OneTermEqualSelector("metadata.name", name).String()
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = selector
+			return c.cs.CoreV1().Secrets(namespace).List(context.Background(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = selector
+			return c.cs.CoreV1().Secrets(namespace).Watch(context.Background(), options)
+		},
+	}
+
+	w := &secretWatch{
+		informer: cache.NewSharedIndexInformer(lw, &corev1.Secret{}, 0, cache.Indexers{}),
+		stop:     make(chan struct{}),
+	}
+
+	err := w.informer.SetWatchErrorHandler(func(r *cache.Reflector, err error) {
+		if apierrors.IsForbidden(err) && w.forbidden.CompareAndSwap(false, true) {
+			log.Logger.Warn().Err(err).Msgf("not allowed to watch secret %s, falling back to direct GET", key)
+			w.close()
+			return
+		}
+		cache.DefaultWatchErrorHandler(r, err)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	go w.informer.Run(w.stop)
+	c.watches[key] = w
+	return w, nil
+}
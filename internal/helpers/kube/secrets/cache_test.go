@@ -0,0 +1,89 @@
+package secrets
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func secret(data string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "finops", Name: "azure", ResourceVersion: data},
+		Data:       map[string][]byte{"token": []byte(data)},
+	}
+}
+
+func TestCacheGet(t *testing.T) {
+	cs := fake.NewSimpleClientset(secret("v1"))
+	c := NewCache(cs)
+	defer c.Stop()
+
+	ctx := context.Background()
+	sec, err := c.Get(ctx, "finops", "azure")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got := string(sec.Data["token"]); got != "v1" {
+		t.Errorf("Get() token = %s, want v1", got)
+	}
+
+	// Rotation: the informer picks up the update
+	if _, err := cs.CoreV1().Secrets("finops").Update(ctx, secret("v2"), metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		sec, err = c.Get(ctx, "finops", "azure")
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		if string(sec.Data["token"]) == "v2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Get() token = %s after the update, want v2", sec.Data["token"])
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestCacheGetNotFound(t *testing.T) {
+	c := NewCache(fake.NewSimpleClientset())
+	defer c.Stop()
+
+	_, err := c.Get(context.Background(), "finops", "missing")
+	if !apierrors.IsNotFound(err) {
+		t.Errorf("Get() error = %v, want NotFound", err)
+	}
+}
+
+func TestCacheGetForbidden(t *testing.T) {
+	cs := fake.NewSimpleClientset(secret("v1"))
+	forbidden := func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewForbidden(corev1.Resource("secrets"), "", nil)
+	}
+	cs.PrependReactor("list", "secrets", forbidden)
+	cs.PrependWatchReactor("secrets", func(action k8stesting.Action) (bool, watch.Interface, error) {
+		return true, nil, apierrors.NewForbidden(corev1.Resource("secrets"), "", nil)
+	})
+
+	c := NewCache(cs)
+	sec, err := c.Get(context.Background(), "finops", "azure")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got := string(sec.Data["token"]); got != "v1" {
+		t.Errorf("Get() token = %s, want v1", got)
+	}
+
+	// The watch has already been stopped by the error handler
+	c.Stop()
+}
@@ -0,0 +1,228 @@
+package otlp
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	configmetrics "github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/config"
+	"github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/delivery"
+	"github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/helpers/kube/httpcall"
+	"github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/resourceid"
+	"github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/selfmetrics"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+const scopeName = "github.com/krateoplatformops/finops-prometheus-resource-exporter-azure"
+
+// Exporter pushes the Azure samples, with their original timestamps, as OTLP gauges: one
+// OTLP resource per Azure resource. Like the remote write output, every sample is sent once.
+type Exporter struct {
+	mu       sync.Mutex
+	opts     configmetrics.OTLP
+	exporter metric.Exporter
+	tracker  *delivery.Tracker
+}
+
+// resourceBatch is the export of the samples of one Azure resource
+type resourceBatch struct {
+	metrics *metricdata.ResourceMetrics
+	// sent is the timestamp of the last sample of every series
+	sent  map[string]time.Time
+	count int
+}
+
+func NewExporter() *Exporter {
+	return &Exporter{tracker: delivery.NewTracker()}
+}
+
+// Export sends the samples not sent yet, one request per Azure resource. The series
+// missing from the samples for longer than gracePeriod are forgotten.
+// The underlying gRPC or HTTP exporter is created on the first call and recreated
+// whenever the configuration changes.
+func (e *Exporter) Export(ctx context.Context, opts configmetrics.OTLP, samples []configmetrics.Sample, gracePeriod time.Duration) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.exporter == nil || !reflect.DeepEqual(e.opts, opts) {
+		if e.exporter != nil {
+			if err := e.exporter.Shutdown(ctx); err != nil {
+				log.Logger.Warn().Err(err).Msg("error while shutting down the previous OTLP exporter")
+			}
+			e.exporter = nil
+		}
+		exporter, err := newExporter(ctx, opts)
+		if err != nil {
+			return fmt.Errorf("could not create the OTLP exporter: %w", err)
+		}
+		e.exporter, e.opts = exporter, opts
+	}
+
+	now := time.Now()
+	batches := e.resourceBatches(samples, now)
+	e.tracker.Forget(now, gracePeriod)
+
+	for _, batch := range batches {
+		if err := e.exporter.Export(ctx, batch.metrics); err != nil {
+			selfmetrics.OTLPSamples.WithLabelValues("failure").Add(float64(batch.count))
+			return fmt.Errorf("OTLP export to %s failed: %w", opts.Endpoint, err)
+		}
+		// The resources already delivered are not sent again if a following one fails
+		selfmetrics.OTLPSamples.WithLabelValues("success").Add(float64(batch.count))
+		for key, ts := range batch.sent {
+			e.tracker.MarkSent(key, ts)
+		}
+	}
+
+	log.Logger.Debug().Msgf("Sent the samples of %d resources to %s", len(batches), opts.Endpoint)
+	return nil
+}
+
+// Shutdown flushes and closes the underlying exporter.
+func (e *Exporter) Shutdown(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.exporter == nil {
+		return nil
+	}
+	err := e.exporter.Shutdown(ctx)
+	e.exporter = nil
+	return err
+}
+
+func newExporter(ctx context.Context, opts configmetrics.OTLP) (metric.Exporter, error) {
+	headers := httpcall.ParseHeaders(opts.Headers)
+
+	switch opts.Protocol {
+	case configmetrics.OTLPProtocolHTTP:
+		options := []otlpmetrichttp.Option{
+			otlpmetrichttp.WithEndpointURL(opts.Endpoint),
+			otlpmetrichttp.WithHeaders(headers),
+			otlpmetrichttp.WithTimeout(opts.Timeout),
+		}
+		if opts.Insecure {
+			options = append(options, otlpmetrichttp.WithInsecure())
+		}
+		return otlpmetrichttp.New(ctx, options...)
+	default:
+		options := []otlpmetricgrpc.Option{
+			otlpmetricgrpc.WithEndpointURL(opts.Endpoint),
+			otlpmetricgrpc.WithHeaders(headers),
+			otlpmetricgrpc.WithTimeout(opts.Timeout),
+		}
+		if opts.Insecure {
+			options = append(options, otlpmetricgrpc.WithInsecure())
+		}
+		return otlpmetricgrpc.New(ctx, options...)
+	}
+}
+
+// resourceBatches groups the samples not sent yet by Azure resource and by metric.
+func (e *Exporter) resourceBatches(samples []configmetrics.Sample, now time.Time) []resourceBatch {
+	type metricKey struct{ name, unit string }
+	type pending struct {
+		resourceId string
+		metrics    map[metricKey][]metricdata.DataPoint[float64]
+		sent       map[string]time.Time
+		count      int
+	}
+	byResource := map[string]*pending{}
+	resourceIds := []string{}
+
+	for _, s := range samples {
+		attrs := make([]attribute.KeyValue, 0, len(s.Labels)+1)
+		attrs = append(attrs, attribute.String("aggregation", s.Aggregation))
+		for k, v := range s.Labels {
+			attrs = append(attrs, attribute.String(k, v))
+		}
+		set := attribute.NewSet(attrs...)
+
+		id := strings.ToLower(s.ResourceId)
+		key := id + "\xff" + s.Metric + "\xff" + set.Encoded(attribute.DefaultEncoder())
+		e.tracker.Seen(key, now)
+		if !e.tracker.ShouldSend(key, s.Timestamp) {
+			continue
+		}
+
+		p, ok := byResource[id]
+		if !ok {
+			p = &pending{resourceId: s.ResourceId, metrics: map[metricKey][]metricdata.DataPoint[float64]{}, sent: map[string]time.Time{}}
+			byResource[id] = p
+			resourceIds = append(resourceIds, id)
+		}
+		if s.Timestamp.After(p.sent[key]) {
+			p.sent[key] = s.Timestamp
+		}
+		mk := metricKey{name: s.Metric, unit: s.Unit}
+		p.metrics[mk] = append(p.metrics[mk], metricdata.DataPoint[float64]{
+			Attributes: set,
+			Time:       s.Timestamp,
+			Value:      s.Value,
+		})
+		p.count++
+	}
+
+	res := make([]resourceBatch, 0, len(resourceIds))
+	for _, id := range resourceIds {
+		p := byResource[id]
+		keys := make([]metricKey, 0, len(p.metrics))
+		for k := range p.metrics {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i].name < keys[j].name })
+
+		scope := metricdata.ScopeMetrics{Scope: instrumentation.Scope{Name: scopeName}}
+		for _, k := range keys {
+			points := p.metrics[k]
+			sort.SliceStable(points, func(i, j int) bool { return points[i].Time.Before(points[j].Time) })
+			scope.Metrics = append(scope.Metrics, metricdata.Metrics{
+				Name: k.name,
+				Unit: k.unit,
+				Data: metricdata.Gauge[float64]{DataPoints: points},
+			})
+		}
+		res = append(res, resourceBatch{
+			metrics: &metricdata.ResourceMetrics{
+				Resource:     resource.NewSchemaless(resourceAttributes(p.resourceId)...),
+				ScopeMetrics: []metricdata.ScopeMetrics{scope},
+			},
+			sent:  p.sent,
+			count: p.count,
+		})
+	}
+	return res
+}
+
+// resourceAttributes describes an Azure resource with the OpenTelemetry cloud semantic
+// conventions, plus the resource group, provider, type and name of the resource ID.
+func resourceAttributes(resourceId string) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.String("service.name", "finops-prometheus-resource-exporter-azure"),
+		attribute.String("cloud.provider", "azure"),
+		attribute.String("cloud.resource_id", resourceId),
+	}
+
+	id, err := resourceid.Parse(resourceId)
+	if err != nil {
+		return attrs
+	}
+	return append(attrs,
+		attribute.String("cloud.account.id", id.SubscriptionID),
+		attribute.String("azure.resource_group", id.ResourceGroup),
+		attribute.String("azure.resource_provider", id.Provider),
+		attribute.String("azure.resource_type", id.Type),
+		attribute.String("azure.resource_name", id.Name),
+	)
+}
@@ -0,0 +1,65 @@
+package otlp
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	configmetrics "github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/config"
+	"go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// fakeExporter records the exported resources, failing for the ones in fail.
+type fakeExporter struct {
+	metric.Exporter
+	fail     map[string]bool
+	exported []string
+}
+
+func (f *fakeExporter) Export(_ context.Context, rm *metricdata.ResourceMetrics) error {
+	id, _ := rm.Resource.Set().Value("cloud.resource_id")
+	if f.fail[id.AsString()] {
+		return errors.New("unavailable")
+	}
+	f.exported = append(f.exported, id.AsString())
+	return nil
+}
+
+func samples(start time.Time, resourceIds ...string) []configmetrics.Sample {
+	res := []configmetrics.Sample{}
+	for _, id := range resourceIds {
+		res = append(res, configmetrics.Sample{ResourceId: id, Metric: "Percentage CPU", Aggregation: "average", Timestamp: start, Value: 1})
+	}
+	return res
+}
+
+func TestExportDoesNotResendDeliveredResources(t *testing.T) {
+	opts := configmetrics.OTLP{Endpoint: "http://collector:4317"}
+	fake := &fakeExporter{fail: map[string]bool{"/subscriptions/s/b": true}}
+	e := NewExporter()
+	e.exporter, e.opts = fake, opts
+
+	start := time.Now()
+	if err := e.Export(context.Background(), opts, samples(start, "/subscriptions/s/a", "/subscriptions/s/b"), time.Hour); err == nil {
+		t.Fatal("Export() succeeded, want the error of the second resource")
+	}
+
+	fake.fail = nil
+	if err := e.Export(context.Background(), opts, samples(start, "/subscriptions/s/a", "/subscriptions/s/b"), time.Hour); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	want := []string{"/subscriptions/s/a", "/subscriptions/s/b"}
+	if len(fake.exported) != len(want) || fake.exported[0] != want[0] || fake.exported[1] != want[1] {
+		t.Errorf("exported %v, want %v", fake.exported, want)
+	}
+
+	// The series missing for longer than the grace period are forgotten
+	if err := e.Export(context.Background(), opts, nil, 0); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	if e.tracker.Len() != 0 {
+		t.Errorf("tracker has %d series, want 0", e.tracker.Len())
+	}
+}
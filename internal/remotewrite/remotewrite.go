@@ -0,0 +1,172 @@
+package remotewrite
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/collector"
+	configmetrics "github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/config"
+	"github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/delivery"
+	"github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/helpers/kube/httpcall"
+	"github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/selfmetrics"
+	"github.com/rs/zerolog/log"
+)
+
+// Writer ships the Azure samples, with their original timestamps, to a Prometheus
+// remote write receiver. Every sample is sent once: the samples of a series older
+// than the last one sent are skipped, since receivers reject out-of-order samples.
+type Writer struct {
+	client *http.Client
+
+	mu      sync.Mutex
+	tracker *delivery.Tracker
+}
+
+func NewWriter() *Writer {
+	return &Writer{
+		client:  &http.Client{},
+		tracker: delivery.NewTracker(),
+	}
+}
+
+// Write sends the samples not sent yet, in WriteRequests of at most opts.BatchSize samples.
+// The series missing from the samples for longer than gracePeriod are forgotten.
+func (w *Writer) Write(ctx context.Context, opts configmetrics.RemoteWrite, samples []configmetrics.Sample, gracePeriod time.Duration) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now()
+	series := w.pending(samples, now)
+	w.tracker.Forget(now, gracePeriod)
+
+	batch := []timeSeries{}
+	batchSamples := 0
+	sent := map[string]time.Time{}
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := w.send(ctx, opts, batch); err != nil {
+			selfmetrics.RemoteWriteSamples.WithLabelValues("failure").Add(float64(batchSamples))
+			return err
+		}
+		selfmetrics.RemoteWriteSamples.WithLabelValues("success").Add(float64(batchSamples))
+		for key, ts := range sent {
+			w.tracker.MarkSent(key, ts)
+		}
+		batch, batchSamples, sent = []timeSeries{}, 0, map[string]time.Time{}
+		return nil
+	}
+
+	for _, s := range series {
+		// A series larger than the batch is split across several requests
+		for len(s.samples) > 0 {
+			n := min(len(s.samples), opts.BatchSize-batchSamples)
+			batch = append(batch, timeSeries{labels: s.labels, samples: s.samples[:n]})
+			batchSamples += n
+			sent[s.key] = time.UnixMilli(s.samples[n-1].timestamp)
+			s.samples = s.samples[n:]
+
+			if batchSamples >= opts.BatchSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return flush()
+}
+
+type pendingSeries struct {
+	key     string
+	labels  []label
+	samples []sample
+}
+
+// pending groups the samples by series, keeping only the ones newer than the last sent.
+func (w *Writer) pending(samples []configmetrics.Sample, now time.Time) []*pendingSeries {
+	bySeries := map[string]*pendingSeries{}
+	keys := []string{}
+	for _, s := range samples {
+		name, labels := collector.SeriesIdentity(s)
+
+		l := []label{{name: "__name__", value: name}}
+		for k, v := range labels {
+			l = append(l, label{name: k, value: v})
+		}
+		sort.Slice(l, func(i, j int) bool { return l[i].name < l[j].name })
+
+		parts := make([]string, 0, len(l))
+		for _, lp := range l {
+			parts = append(parts, lp.name+"="+lp.value)
+		}
+		key := strings.Join(parts, "\xff")
+		w.tracker.Seen(key, now)
+		if !w.tracker.ShouldSend(key, s.Timestamp) {
+			continue
+		}
+
+		ps, ok := bySeries[key]
+		if !ok {
+			ps = &pendingSeries{key: key, labels: l}
+			bySeries[key] = ps
+			keys = append(keys, key)
+		}
+		ps.samples = append(ps.samples, sample{value: s.Value, timestamp: s.Timestamp.UnixMilli()})
+	}
+
+	res := make([]*pendingSeries, 0, len(keys))
+	for _, key := range keys {
+		ps := bySeries[key]
+		sort.Slice(ps.samples, func(i, j int) bool { return ps.samples[i].timestamp < ps.samples[j].timestamp })
+		res = append(res, ps)
+	}
+	return res
+}
+
+// send posts a single WriteRequest, retrying it according to the retry policy.
+func (w *Writer) send(ctx context.Context, opts configmetrics.RemoteWrite, series []timeSeries) error {
+	body := snappy.Encode(nil, marshalWriteRequest(series))
+
+	res, err := opts.Retry.Do(ctx, func(ctx context.Context) (*http.Response, error) {
+		reqCtx, cancel := context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, opts.URL, bytes.NewReader(body))
+		if err != nil {
+			return nil, httpcall.Permanent(err)
+		}
+		req.Header.Set("Content-Encoding", "snappy")
+		req.Header.Set("Content-Type", "application/x-protobuf")
+		req.Header.Set("User-Agent", "finops-prometheus-resource-exporter-azure")
+		req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+		for name, value := range httpcall.ParseHeaders(opts.Headers) {
+			req.Header.Set(name, value)
+		}
+
+		resp, err := w.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		// The body is read before the request context is cancelled
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = io.NopCloser(bytes.NewReader(data))
+		return resp, err
+	})
+	if err != nil {
+		return fmt.Errorf("remote write to %s failed: %w", opts.URL, err)
+	}
+	res.Body.Close()
+
+	log.Logger.Debug().Msgf("Sent %d series to %s", len(series), opts.URL)
+	return nil
+}
@@ -0,0 +1,219 @@
+package remotewrite
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	configmetrics "github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/config"
+	"github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/helpers/kube/httpcall"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// receiver is a fake remote write receiver, decoding every WriteRequest it accepts.
+type receiver struct {
+	mu       sync.Mutex
+	requests [][]timeSeries
+	// failures is the number of requests to answer with a 503 before accepting them
+	failures int
+	calls    int
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.calls++
+	if req.Header.Get("Content-Encoding") != "snappy" || req.Header.Get("Content-Type") != "application/x-protobuf" {
+		http.Error(w, "unexpected headers", http.StatusBadRequest)
+		return
+	}
+	if r.failures > 0 {
+		r.failures--
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	compressed, _ := io.ReadAll(req.Body)
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	series, err := unmarshalWriteRequest(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.requests = append(r.requests, series)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// fields calls fn for every field of a protobuf message
+func fields(data []byte, fn func(num protowire.Number, typ protowire.Type, value []byte) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		m := protowire.ConsumeFieldValue(num, typ, data)
+		if m < 0 {
+			return protowire.ParseError(m)
+		}
+		if err := fn(num, typ, data[:m]); err != nil {
+			return err
+		}
+		data = data[m:]
+	}
+	return nil
+}
+
+func unmarshalWriteRequest(data []byte) ([]timeSeries, error) {
+	res := []timeSeries{}
+	err := fields(data, func(_ protowire.Number, _ protowire.Type, value []byte) error {
+		ts := timeSeries{}
+		bytesValue, _ := protowire.ConsumeBytes(value)
+		err := fields(bytesValue, func(num protowire.Number, _ protowire.Type, value []byte) error {
+			msg, _ := protowire.ConsumeBytes(value)
+			switch num {
+			case 1:
+				l := label{}
+				err := fields(msg, func(num protowire.Number, _ protowire.Type, value []byte) error {
+					str, _ := protowire.ConsumeString(value)
+					if num == 1 {
+						l.name = str
+					} else {
+						l.value = str
+					}
+					return nil
+				})
+				ts.labels = append(ts.labels, l)
+				return err
+			case 2:
+				s := sample{}
+				err := fields(msg, func(num protowire.Number, _ protowire.Type, value []byte) error {
+					if num == 1 {
+						bits, _ := protowire.ConsumeFixed64(value)
+						s.value = math.Float64frombits(bits)
+					} else {
+						v, _ := protowire.ConsumeVarint(value)
+						s.timestamp = int64(v)
+					}
+					return nil
+				})
+				ts.samples = append(ts.samples, s)
+				return err
+			}
+			return nil
+		})
+		res = append(res, ts)
+		return err
+	})
+	return res, err
+}
+
+func options(url string) configmetrics.RemoteWrite {
+	return configmetrics.RemoteWrite{
+		URL:       url,
+		BatchSize: 2,
+		Timeout:   5 * time.Second,
+		Retry: httpcall.RetryPolicy{
+			MaxAttempts:          3,
+			BaseBackoff:          time.Millisecond,
+			MaxBackoff:           10 * time.Millisecond,
+			RetryableStatusCodes: []int{http.StatusServiceUnavailable},
+		},
+	}
+}
+
+const resourceId = "/subscriptions/s/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm"
+
+func testSamples(start time.Time) []configmetrics.Sample {
+	res := []configmetrics.Sample{}
+	for i := 0; i < 3; i++ {
+		res = append(res, configmetrics.Sample{
+			ResourceId:  resourceId,
+			Metric:      "Percentage CPU",
+			Unit:        "Percent",
+			Aggregation: "average",
+			Timestamp:   start.Add(time.Duration(i) * time.Minute),
+			Value:       float64(i),
+		})
+	}
+	return res
+}
+
+func TestWrite(t *testing.T) {
+	r := &receiver{failures: 1}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	start := time.Date(2024, 9, 10, 8, 0, 0, 0, time.UTC)
+	w := NewWriter()
+	if err := w.Write(context.Background(), options(server.URL), testSamples(start), time.Hour); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	// 3 samples in batches of 2, the first request retried after the 503
+	if r.calls != 3 || len(r.requests) != 2 {
+		t.Fatalf("receiver got %d calls and %d requests, want 3 and 2", r.calls, len(r.requests))
+	}
+
+	got := []sample{}
+	for _, req := range r.requests {
+		for _, ts := range req {
+			labels := map[string]string{}
+			for i, l := range ts.labels {
+				if i > 0 && ts.labels[i-1].name >= l.name {
+					t.Errorf("labels not sorted: %v", ts.labels)
+				}
+				labels[l.name] = l.value
+			}
+			if labels["__name__"] != "percentage_cpu" || labels["ResourceId"] != resourceId || labels["aggregation"] != "average" {
+				t.Errorf("unexpected labels %v", labels)
+			}
+			got = append(got, ts.samples...)
+		}
+	}
+	for i, s := range got {
+		want := sample{value: float64(i), timestamp: start.Add(time.Duration(i) * time.Minute).UnixMilli()}
+		if s != want {
+			t.Errorf("sample %d = %+v, want %+v", i, s, want)
+		}
+	}
+
+	// The samples already sent are not sent again
+	if err := w.Write(context.Background(), options(server.URL), testSamples(start), time.Hour); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if len(r.requests) != 2 {
+		t.Errorf("receiver got %d requests after a second write of the same samples, want 2", len(r.requests))
+	}
+}
+
+func TestWriteForgetsStaleSeries(t *testing.T) {
+	server := httptest.NewServer(&receiver{})
+	defer server.Close()
+
+	w := NewWriter()
+	if err := w.Write(context.Background(), options(server.URL), testSamples(time.Now()), time.Hour); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if w.tracker.Len() != 1 {
+		t.Fatalf("tracker has %d series, want 1", w.tracker.Len())
+	}
+
+	if err := w.Write(context.Background(), options(server.URL), nil, 0); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if w.tracker.Len() != 0 {
+		t.Errorf("tracker has %d series after the grace period, want 0", w.tracker.Len())
+	}
+}
@@ -0,0 +1,174 @@
+package resourcegraph
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	configmetrics "github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/config"
+	"github.com/rs/zerolog/log"
+
+	finopsdatatypes "github.com/krateoplatformops/finops-data-types/api/v1"
+)
+
+// maxPages bounds the pagination of a single query, in case the API keeps returning a skip token
+const maxPages = 1000
+
+type request struct {
+	Subscriptions    []string       `json:"subscriptions,omitempty"`
+	ManagementGroups []string       `json:"managementGroups,omitempty"`
+	Query            string         `json:"query"`
+	Options          requestOptions `json:"options"`
+}
+
+type requestOptions struct {
+	SkipToken    string `json:"$skipToken,omitempty"`
+	ResultFormat string `json:"resultFormat"`
+}
+
+type response struct {
+	TotalRecords int64            `json:"totalRecords"`
+	Data         []map[string]any `json:"data"`
+	SkipToken    string           `json:"$skipToken"`
+}
+
+// Discoverer runs the Resource Graph query and keeps the discovered resources until the
+// refresh interval elapses or the query changes. When a refresh fails, the last resources are kept.
+type Discoverer struct {
+	mu        sync.Mutex
+	opts      configmetrics.ResourceGraph
+	resources []configmetrics.Resource
+	refreshed time.Time
+}
+
+func NewDiscoverer() *Discoverer {
+	return &Discoverer{}
+}
+
+// Resources returns the resources to scrape, running the query again when needed. The
+// query uses the endpoint reference and the timeout of target.
+func (d *Discoverer) Resources(ctx context.Context, opts configmetrics.ResourceGraph, target configmetrics.Target, fetch configmetrics.Fetch) ([]configmetrics.Resource, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.refreshed.IsZero() || time.Since(d.refreshed) >= opts.RefreshInterval || !reflect.DeepEqual(d.opts, opts) {
+		rows, err := query(ctx, opts, target, fetch)
+		if err != nil {
+			if d.refreshed.IsZero() || !reflect.DeepEqual(d.opts, opts) {
+				return nil, err
+			}
+			log.Logger.Warn().Err(err).Msgf("error while refreshing the resources, keeping the %d discovered at %s", len(d.resources), d.refreshed.Format(time.RFC3339))
+			return d.resources, nil
+		}
+
+		d.resources = Resources(rows, opts.Templates)
+		d.opts, d.refreshed = opts, time.Now()
+		log.Logger.Info().Msgf("Discovered %d resources to scrape out of %d", len(d.resources), len(rows))
+	}
+	return d.resources, nil
+}
+
+// query runs the query, following the skip tokens until all the rows are read.
+func query(ctx context.Context, opts configmetrics.ResourceGraph, target configmetrics.Target, fetch configmetrics.Fetch) ([]map[string]any, error) {
+	rows := []map[string]any{}
+	req := request{
+		Subscriptions:    opts.Subscriptions,
+		ManagementGroups: opts.ManagementGroups,
+		Query:            opts.Query,
+		Options:          requestOptions{ResultFormat: "objectArray"},
+	}
+
+	for page := 0; page < maxPages; page++ {
+		payload, err := json.Marshal(req)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := fetch(ctx, configmetrics.Target{
+			API: finopsdatatypes.API{
+				Path:        "providers/Microsoft.ResourceGraph/resources?api-version=" + opts.APIVersion,
+				Verb:        "POST",
+				Headers:     []string{"Content-Type:application/json"},
+				Payload:     string(payload),
+				EndpointRef: target.API.EndpointRef,
+			},
+			Timeout: target.Timeout,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error while running the Resource Graph query: %w", err)
+		}
+
+		res := response{}
+		if err := json.Unmarshal(data, &res); err != nil {
+			return nil, fmt.Errorf("error while decoding the Resource Graph response: %w", err)
+		}
+		rows = append(rows, res.Data...)
+
+		if res.SkipToken == "" {
+			return rows, nil
+		}
+		req.Options.SkipToken = res.SkipToken
+	}
+	return nil, fmt.Errorf("the Resource Graph query returned more than %d pages", maxPages)
+}
+
+// Resources turns the rows of the query into resources, applying the template of their type.
+// The string and number columns become variables, ResourceId being the id column.
+func Resources(rows []map[string]any, templates []configmetrics.ResourceTemplate) []configmetrics.Resource {
+	res := []configmetrics.Resource{}
+	for _, row := range rows {
+		id, _ := row["id"].(string)
+		if id == "" {
+			continue
+		}
+		resourceType, _ := row["type"].(string)
+		template, ok := templateOf(resourceType, templates)
+		if !ok {
+			log.Logger.Debug().Msgf("no template for type %s, skipping resource %s", resourceType, id)
+			continue
+		}
+
+		variables := map[string]string{}
+		for column, value := range row {
+			switch v := value.(type) {
+			case string:
+				variables[column] = v
+			case float64, bool:
+				variables[column] = fmt.Sprint(v)
+			}
+		}
+		for k, v := range template.AdditionalVariables {
+			variables[k] = v
+		}
+		variables["ResourceId"] = id
+
+		res = append(res, configmetrics.Resource{
+			Path:                template.Path,
+			AdditionalVariables: variables,
+			RequestTimeout:      template.RequestTimeout,
+		})
+	}
+	return res
+}
+
+// templateOf returns the template of a resource type, or the one without type.
+func templateOf(resourceType string, templates []configmetrics.ResourceTemplate) (configmetrics.ResourceTemplate, bool) {
+	var fallback *configmetrics.ResourceTemplate
+	for i, template := range templates {
+		if template.Type == "" {
+			fallback = &templates[i]
+			continue
+		}
+		if strings.EqualFold(template.Type, resourceType) {
+			return template, true
+		}
+	}
+	if fallback != nil {
+		return *fallback, true
+	}
+	return configmetrics.ResourceTemplate{}, false
+}
@@ -0,0 +1,85 @@
+package resourceid
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ResourceID is an Azure resource ID split into its parts. Azure returns the IDs in
+// inconsistent case, so every part is lower-cased.
+type ResourceID struct {
+	SubscriptionID string
+	ResourceGroup  string
+	// Provider is the resource provider namespace, e.g. microsoft.sql
+	Provider string
+	// Type is the full resource type, child types included, e.g. microsoft.sql/servers/databases
+	Type string
+	// Name is the name of the resource itself, e.g. the database of a SQL server
+	Name string
+}
+
+// Parse splits an Azure resource ID, such as
+//
+//	/subscriptions/{id}/resourceGroups/{group}/providers/Microsoft.Sql/servers/{server}/databases/{database}
+//
+// into its parts. Subscription and resource group IDs are supported too. For extension
+// resources, whose ID has more than one providers segment, the last resource is returned.
+func Parse(id string) (ResourceID, error) {
+	segments := strings.Split(strings.ToLower(strings.Trim(id, "/")), "/")
+	if len(segments) < 2 || segments[0] != "subscriptions" || segments[1] == "" {
+		return ResourceID{}, fmt.Errorf("invalid Azure resource ID %q: it must start with /subscriptions/{id}", id)
+	}
+
+	res := ResourceID{SubscriptionID: segments[1], Type: "microsoft.resources/subscriptions", Name: segments[1]}
+	rest := segments[2:]
+
+	if len(rest) >= 2 && rest[0] == "resourcegroups" {
+		res.ResourceGroup = rest[1]
+		res.Type, res.Name = "microsoft.resources/resourcegroups", rest[1]
+		rest = rest[2:]
+	}
+
+	for len(rest) > 0 {
+		if rest[0] != "providers" || len(rest) < 4 {
+			return ResourceID{}, fmt.Errorf("invalid Azure resource ID %q: expected providers/{namespace}/{type}/{name}", id)
+		}
+		res.Provider = rest[1]
+		types := []string{rest[1], rest[2]}
+		res.Name = rest[3]
+		rest = rest[4:]
+
+		// Child resources follow as {type}/{name} pairs until the next providers segment
+		for len(rest) > 0 && rest[0] != "providers" {
+			if len(rest) < 2 {
+				return ResourceID{}, fmt.Errorf("invalid Azure resource ID %q: child type %s has no name", id, rest[0])
+			}
+			types = append(types, rest[0])
+			res.Name = rest[1]
+			rest = rest[2:]
+		}
+		res.Type = strings.Join(types, "/")
+	}
+
+	return res, nil
+}
+
+// Labels returns the parts of the resource ID as Prometheus labels.
+func (r ResourceID) Labels() map[string]string {
+	return map[string]string{
+		"subscription_id":   r.SubscriptionID,
+		"resource_group":    r.ResourceGroup,
+		"resource_provider": r.Provider,
+		"resource_type":     r.Type,
+		"resource_name":     r.Name,
+	}
+}
+
+// Type returns the full resource type of an Azure resource ID, e.g. microsoft.compute/virtualmachines,
+// or an empty string when the ID cannot be parsed.
+func Type(id string) string {
+	res, err := Parse(id)
+	if err != nil {
+		return ""
+	}
+	return res.Type
+}
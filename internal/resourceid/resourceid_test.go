@@ -0,0 +1,142 @@
+package resourceid
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		id      string
+		want    ResourceID
+		wantErr bool
+	}{
+		{
+			name: "subscription",
+			id:   "/subscriptions/00000000-0000-0000-0000-000000000000",
+			want: ResourceID{
+				SubscriptionID: "00000000-0000-0000-0000-000000000000",
+				Type:           "microsoft.resources/subscriptions",
+				Name:           "00000000-0000-0000-0000-000000000000",
+			},
+		},
+		{
+			name: "resource group",
+			id:   "/subscriptions/sub/resourceGroups/rg",
+			want: ResourceID{
+				SubscriptionID: "sub",
+				ResourceGroup:  "rg",
+				Type:           "microsoft.resources/resourcegroups",
+				Name:           "rg",
+			},
+		},
+		{
+			name: "resource",
+			id:   "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm",
+			want: ResourceID{
+				SubscriptionID: "sub",
+				ResourceGroup:  "rg",
+				Provider:       "microsoft.compute",
+				Type:           "microsoft.compute/virtualmachines",
+				Name:           "vm",
+			},
+		},
+		{
+			name: "nested SQL database",
+			id:   "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Sql/servers/x/databases/y",
+			want: ResourceID{
+				SubscriptionID: "sub",
+				ResourceGroup:  "rg",
+				Provider:       "microsoft.sql",
+				Type:           "microsoft.sql/servers/databases",
+				Name:           "y",
+			},
+		},
+		{
+			name: "subscription-level resource",
+			id:   "/subscriptions/sub/providers/Microsoft.Insights/actionGroups/ag",
+			want: ResourceID{
+				SubscriptionID: "sub",
+				Provider:       "microsoft.insights",
+				Type:           "microsoft.insights/actiongroups",
+				Name:           "ag",
+			},
+		},
+		{
+			name: "extension resource",
+			id:   "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Storage/storageAccounts/sa/providers/Microsoft.Insights/diagnosticSettings/ds",
+			want: ResourceID{
+				SubscriptionID: "sub",
+				ResourceGroup:  "rg",
+				Provider:       "microsoft.insights",
+				Type:           "microsoft.insights/diagnosticsettings",
+				Name:           "ds",
+			},
+		},
+		{
+			name: "extension of a child resource",
+			id:   "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Sql/servers/x/databases/y/providers/Microsoft.Authorization/locks/l",
+			want: ResourceID{
+				SubscriptionID: "sub",
+				ResourceGroup:  "rg",
+				Provider:       "microsoft.authorization",
+				Type:           "microsoft.authorization/locks",
+				Name:           "l",
+			},
+		},
+		{
+			name: "mixed case and trailing slash",
+			id:   "/SUBSCRIPTIONS/Sub/resourcegroups/RG/PROVIDERS/microsoft.sql/Servers/X/Databases/Y/",
+			want: ResourceID{
+				SubscriptionID: "sub",
+				ResourceGroup:  "rg",
+				Provider:       "microsoft.sql",
+				Type:           "microsoft.sql/servers/databases",
+				Name:           "y",
+			},
+		},
+		{
+			name:    "child type without name",
+			id:      "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Sql/servers/x/databases",
+			wantErr: true,
+		},
+		{
+			name:    "missing resource name",
+			id:      "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines",
+			wantErr: true,
+		},
+		{
+			name:    "missing providers segment",
+			id:      "/subscriptions/sub/resourceGroups/rg/Microsoft.Compute/virtualMachines/vm",
+			wantErr: true,
+		},
+		{
+			name:    "not starting with subscriptions",
+			id:      "/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm",
+			wantErr: true,
+		},
+		{
+			name:    "missing subscription ID",
+			id:      "/subscriptions/",
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			id:      "",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.id)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse(%q) error = %v, wantErr %v", tt.id, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Parse(%q) = %+v, want %+v", tt.id, got, tt.want)
+			}
+			if gotType := Type(tt.id); gotType != tt.want.Type {
+				t.Errorf("Type(%q) = %q, want %q", tt.id, gotType, tt.want.Type)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package selfmetrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	namespace = "finops_exporter"
+)
+
+var (
+	ConfigLastReloadSuccessful = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "config_last_reload_successful",
+		Help:      "Whether the last configuration reload attempt was successful.",
+	})
+
+	ConfigLastReloadSuccessTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "config_last_reload_success_timestamp_seconds",
+		Help:      "Timestamp of the last successful configuration reload.",
+	})
+
+	AzureRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "azure_requests_total",
+		Help:      "Azure API requests, by status code (\"timeout\" or \"error\" when no response was received).",
+	}, []string{"code"})
+
+	AzureRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "azure_request_duration_seconds",
+		Help:      "Latency of the Azure API requests, until the response headers are received, by status code.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"code"})
+
+	AzureRequestFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "azure_request_failures_total",
+		Help:      "Azure API calls that failed after all the retries, by status code (\"timeout\" or \"error\" when no response was received).",
+	}, []string{"code"})
+
+	LastSuccessfulScrapeTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_successful_scrape_timestamp_seconds",
+		Help:      "Timestamp of the last scrape in which at least one Azure API call succeeded.",
+	})
+
+	SamplesParsed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "samples_parsed_total",
+		Help:      "Samples decoded from the Azure API responses.",
+	})
+
+	DecodeErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "decode_errors_total",
+		Help:      "Azure API responses that could not be decoded.",
+	})
+
+	TokenRefreshes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "token_refreshes_total",
+		Help:      "Azure AD access token requests, by authentication type and result.",
+	}, []string{"auth_type", "result"})
+
+	ExportedSeries = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "exported_series",
+		Help:      "Number of Azure Monitor series currently exported.",
+	})
+
+	RemoteWriteSamples = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "remote_write_samples_total",
+		Help:      "Samples sent to the remote write receiver, by result.",
+	}, []string{"result"})
+
+	OTLPSamples = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "otlp_samples_total",
+		Help:      "Samples exported through OTLP, by result.",
+	}, []string{"result"})
+)
+
+// Register registers the exporter self-metrics with the registry.
+func Register(reg prometheus.Registerer) {
+	reg.MustRegister(
+		ConfigLastReloadSuccessful,
+		ConfigLastReloadSuccessTimestamp,
+		AzureRequests,
+		AzureRequestDuration,
+		AzureRequestFailures,
+		LastSuccessfulScrapeTimestamp,
+		SamplesParsed,
+		DecodeErrors,
+		TokenRefreshes,
+		ExportedSeries,
+		RemoteWriteSamples,
+		OTLPSamples,
+	)
+}
@@ -0,0 +1,171 @@
+package tags
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	configmetrics "github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/config"
+	"github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/utils"
+	"github.com/rs/zerolog/log"
+
+	finopsdatatypes "github.com/krateoplatformops/finops-data-types/api/v1"
+)
+
+// InfoMetric is the name of the metric exported, in info mode, for every resource
+const InfoMetric = "azure_resource_info"
+
+// labelPrefix avoids collisions between the tag labels and the other ones
+const labelPrefix = "tag_"
+
+type response struct {
+	Properties struct {
+		Tags map[string]string `json:"tags"`
+	} `json:"properties"`
+}
+
+type entry struct {
+	tags    map[string]string
+	expires time.Time
+}
+
+// Cache holds the tags of the resources, read through the Tags API, for the configured TTL.
+type Cache struct {
+	mu      sync.Mutex
+	entries map[string]entry
+}
+
+func NewCache() *Cache {
+	return &Cache{entries: map[string]entry{}}
+}
+
+// Labels returns, for every resource with at least one allowed tag, the labels of its allowed
+// tags, keyed by the lower-cased resource ID. The resources whose tags cannot be read are skipped.
+func (c *Cache) Labels(ctx context.Context, opts configmetrics.Tags, resourceIds []string, target configmetrics.Target, fetch configmetrics.Fetch, maxConcurrency int) map[string]map[string]string {
+	missing := []string{}
+	c.mu.Lock()
+	for _, id := range resourceIds {
+		if e, ok := c.entries[strings.ToLower(id)]; !ok || time.Now().After(e.expires) {
+			missing = append(missing, id)
+		}
+	}
+	c.mu.Unlock()
+
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < min(maxConcurrency, len(missing)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range jobs {
+				tags, err := get(ctx, opts, id, target, fetch)
+				if err != nil {
+					log.Logger.Warn().Err(err).Msgf("error while reading the tags of %s", id)
+					continue
+				}
+				c.mu.Lock()
+				c.entries[strings.ToLower(id)] = entry{tags: tags, expires: time.Now().Add(opts.CacheTTL)}
+				c.mu.Unlock()
+			}
+		}()
+	}
+	for _, id := range missing {
+		jobs <- id
+	}
+	close(jobs)
+	wg.Wait()
+
+	res := map[string]map[string]string{}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, id := range resourceIds {
+		e, ok := c.entries[strings.ToLower(id)]
+		if !ok {
+			continue
+		}
+		if labels := Allowed(e.tags, opts.Allow); len(labels) > 0 {
+			res[strings.ToLower(id)] = labels
+		}
+	}
+	return res
+}
+
+func get(ctx context.Context, opts configmetrics.Tags, resourceId string, target configmetrics.Target, fetch configmetrics.Fetch) (map[string]string, error) {
+	data, err := fetch(ctx, configmetrics.Target{
+		ResourceId: resourceId,
+		API: finopsdatatypes.API{
+			Path:        strings.TrimPrefix(resourceId, "/") + "/providers/Microsoft.Resources/tags/default?api-version=" + opts.APIVersion,
+			Verb:        "GET",
+			EndpointRef: target.API.EndpointRef,
+		},
+		Timeout: target.Timeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res := response{}
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, fmt.Errorf("error while decoding the tags: %w", err)
+	}
+	return res.Properties.Tags, nil
+}
+
+// Allowed returns the labels of the allowed tags: tag names are case-insensitive and
+// become sanitized label names with the tag_ prefix, e.g. CostCenter becomes tag_costcenter.
+func Allowed(tags map[string]string, allow []string) map[string]string {
+	labels := map[string]string{}
+	for name, value := range tags {
+		for _, allowed := range allow {
+			if strings.EqualFold(name, allowed) {
+				labels[labelPrefix+utils.SanitizeLabelName(name)] = value
+				break
+			}
+		}
+	}
+	return labels
+}
+
+// AddLabels adds the tag labels of its resource to every sample.
+func AddLabels(samples []configmetrics.Sample, labels map[string]map[string]string) {
+	for i := range samples {
+		tagLabels, ok := labels[strings.ToLower(samples[i].ResourceId)]
+		if !ok {
+			continue
+		}
+		merged := make(map[string]string, len(samples[i].Labels)+len(tagLabels))
+		for k, v := range samples[i].Labels {
+			merged[k] = v
+		}
+		for k, v := range tagLabels {
+			merged[k] = v
+		}
+		samples[i].Labels = merged
+	}
+}
+
+// InfoSamples returns one azure_resource_info sample, of value 1, per tagged resource,
+// to be joined in PromQL with the metrics of the resource on the ResourceId label.
+func InfoSamples(resourceIds []string, labels map[string]map[string]string, now time.Time) []configmetrics.Sample {
+	res := []configmetrics.Sample{}
+	seen := map[string]bool{}
+	for _, id := range resourceIds {
+		key := strings.ToLower(id)
+		tagLabels, ok := labels[key]
+		if !ok || seen[key] {
+			continue
+		}
+		seen[key] = true
+		res = append(res, configmetrics.Sample{
+			ResourceId: id,
+			Metric:     InfoMetric,
+			Timestamp:  now,
+			Value:      1,
+			Labels:     tagLabels,
+		})
+	}
+	return res
+}
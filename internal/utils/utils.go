@@ -2,9 +2,11 @@ package utils
 
 import (
 	"bytes"
+	"fmt"
 	"os"
 	"regexp"
 	"strings"
+	"unicode"
 
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/kubernetes"
@@ -62,3 +64,75 @@ func ReplaceVariables(text string, additionalVariables map[string]string) string
 
 	return text
 }
+
+// SanitizeLabelName turns an arbitrary string, such as an Azure Monitor dimension name,
+// into a valid Prometheus label name: lowercase, with every invalid character replaced by "_"
+func SanitizeLabelName(name string) string {
+	sanitized := strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToLower(r)
+		}
+		return '_'
+	}, name)
+
+	if sanitized == "" || unicode.IsDigit(rune(sanitized[0])) {
+		sanitized = "_" + sanitized
+	}
+	return sanitized
+}
+
+// Glob compiles a glob pattern into an anchored, case-insensitive regular expression.
+// "*" matches any sequence of characters, "/" included, "?" any single character and
+// "[...]" a character class, negated by a leading "!" or "^". "\" escapes the next character.
+func Glob(pattern string) (*regexp.Regexp, error) {
+	expr := strings.Builder{}
+	expr.WriteString("(?is)^")
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; r {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		case '\\':
+			if i+1 == len(runes) {
+				return nil, fmt.Errorf("invalid pattern %q: trailing escape", pattern)
+			}
+			i++
+			expr.WriteString(regexp.QuoteMeta(string(runes[i])))
+		case '[':
+			end := i + 1
+			if end < len(runes) && (runes[end] == '!' || runes[end] == '^') {
+				end++
+			}
+			// A "]" right after the opening bracket is part of the class
+			if end < len(runes) && runes[end] == ']' {
+				end++
+			}
+			for end < len(runes) && runes[end] != ']' {
+				end++
+			}
+			if end == len(runes) {
+				return nil, fmt.Errorf("invalid pattern %q: unterminated character class", pattern)
+			}
+			class := runes[i+1 : end]
+			expr.WriteString("[")
+			if len(class) > 0 && class[0] == '!' {
+				expr.WriteString("^")
+				class = class[1:]
+			}
+			expr.WriteString(strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`).Replace(string(class)))
+			expr.WriteString("]")
+			i = end
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expr.WriteString("$")
+
+	res, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	return res, nil
+}
@@ -2,221 +2,514 @@ package main
 
 import (
 	"context"
-	"encoding/csv"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
+	"os/signal"
 	"strconv"
-	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/collector"
 	configmetrics "github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/config"
+	"github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/discovery"
+	"github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/health"
 	"github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/helpers/kube/endpoints"
 	"github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/helpers/kube/httpcall"
+	"github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/helpers/kube/secrets"
+	"github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/otlp"
+	"github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/remotewrite"
+	"github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/resourcegraph"
+	"github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/selfmetrics"
+	"github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/tags"
 	"github.com/krateoplatformops/finops-prometheus-resource-exporter-azure/internal/utils"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog/log"
-	"gopkg.in/yaml.v3"
 
 	finopsdatatypes "github.com/krateoplatformops/finops-data-types/api/v1"
 )
 
-type recordGaugeCombo struct {
-	record []string
-	gauge  prometheus.Gauge
+const configFile = "/config/config.yaml"
+
+// endpointSecrets serves the endpoint Secrets, nil to read them directly from the API server
+var endpointSecrets secrets.Getter
+
+// resolveEndpoint reads the endpoint referenced by the API
+func resolveEndpoint(ctx context.Context, api *finopsdatatypes.API) (*httpcall.Endpoint, error) {
+	rc, _ := rest.InClusterConfig()
+
+	return endpoints.Resolve(ctx, endpoints.ResolveOptions{
+		RESTConfig: rc,
+		API:        api,
+		Secrets:    endpointSecrets,
+	})
 }
 
-func ParseConfigFile(file string) (finopsdatatypes.ExporterScraperConfig, *httpcall.Endpoint, error) {
-	fileReader, err := os.OpenFile(file, os.O_RDONLY, 0600)
-	if err != nil {
-		return finopsdatatypes.ExporterScraperConfig{}, &httpcall.Endpoint{}, err
+// targetEndpoint returns a copy of the endpoint with the target server URL, scope or variables replaced in it
+func targetEndpoint(endpoint *httpcall.Endpoint, target configmetrics.Target) *httpcall.Endpoint {
+	res := *endpoint
+	res.ServerURL = utils.ReplaceVariables(res.ServerURL, target.Variables)
+	if target.ServerURL != "" {
+		res.ServerURL = target.ServerURL
+	}
+	if target.Scope != "" {
+		res.Scope = target.Scope
 	}
-	defer fileReader.Close()
-	data, err := io.ReadAll(fileReader)
+	return &res
+}
 
+func makeAPIRequest(ctx context.Context, target configmetrics.Target, endpoint *httpcall.Endpoint, policy httpcall.RetryPolicy) ([]byte, error) {
+	data, err := requestPage(ctx, target, endpoint, policy, "")
 	if err != nil {
-		return finopsdatatypes.ExporterScraperConfig{}, &httpcall.Endpoint{}, err
+		return nil, err
 	}
 
-	parse := finopsdatatypes.ExporterScraperConfig{}
+	// Large responses are split in pages, all of them are read before decoding
+	return httpcall.Paginate(ctx, data, func(ctx context.Context, nextLink string) ([]byte, error) {
+		page := target
+		page.API.Verb = http.MethodGet
+		page.API.Payload = ""
+		return requestPage(ctx, page, endpoint, policy, nextLink)
+	})
+}
 
-	err = yaml.Unmarshal(data, &parse)
-	if err != nil {
-		return finopsdatatypes.ExporterScraperConfig{}, &httpcall.Endpoint{}, err
-	}
+// requestPage makes a single API call, retried according to the policy: the one of the
+// target or, when nextLink is set, the one of a further page of its response.
+func requestPage(ctx context.Context, target configmetrics.Target, endpoint *httpcall.Endpoint, policy httpcall.RetryPolicy, nextLink string) ([]byte, error) {
+	attempt := 0
+	res, err := policy.Do(ctx, func(ctx context.Context) (*http.Response, error) {
+		attempt++
+		if attempt > 1 {
+			// The credentials may have been rotated since the last attempt
+			log.Logger.Info().Msgf("Parsing Endpoint again...")
+			resolved, err := resolveEndpoint(ctx, &target.API)
+			if err != nil {
+				return nil, err
+			}
+			endpoint = targetEndpoint(resolved, target)
+		}
 
-	rc, _ := rest.InClusterConfig()
+		httpClient, err := httpcall.HTTPClientForEndpoint(endpoint)
+		if err != nil {
+			return nil, httpcall.Permanent(fmt.Errorf("error while creating HTTP client: %w", err))
+		}
 
-	endpoint, err := endpoints.Resolve(context.Background(), endpoints.ResolveOptions{
-		RESTConfig: rc,
-		API:        &parse.Spec.ExporterConfig.API,
+		return httpcall.Do(ctx, httpClient, httpcall.Options{
+			API:      &target.API,
+			Endpoint: endpoint,
+			Timeout:  target.Timeout,
+			URL:      nextLink,
+		})
 	})
 	if err != nil {
-		return finopsdatatypes.ExporterScraperConfig{}, &httpcall.Endpoint{}, err
+		return nil, err
 	}
+	defer res.Body.Close()
 
-	// Replace variables in server URL
-	endpoint.ServerURL = utils.ReplaceVariables(endpoint.ServerURL, parse.Spec.ExporterConfig.AdditionalVariables)
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("an error has occured while reading response body: %w", err)
+	}
 
-	// Replace variables in API path
-	parse.Spec.ExporterConfig.API.Path = utils.ReplaceVariables(parse.Spec.ExporterConfig.API.Path, parse.Spec.ExporterConfig.AdditionalVariables)
+	return utils.TrapBOM(data), nil
+}
 
-	return parse, endpoint, nil
+// scrapeTarget calls the Azure API of a target and decodes its samples. A failed
+// target is reported and skipped, without blocking the other ones.
+func scrapeTarget(ctx context.Context, target configmetrics.Target, endpoint *httpcall.Endpoint, policy httpcall.RetryPolicy) ([]configmetrics.Sample, error) {
+	data, err := makeAPIRequest(ctx, target, targetEndpoint(endpoint, target), policy)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		code := "error"
+		var statusErr *httpcall.StatusError
+		var timeoutErr *httpcall.TimeoutError
+		if errors.As(err, &statusErr) {
+			code = strconv.Itoa(statusErr.StatusCode)
+		} else if errors.As(err, &timeoutErr) {
+			code = "timeout"
+		}
+		selfmetrics.AzureRequestFailures.WithLabelValues(code).Inc()
+		log.Logger.Error().Err(err).Msgf("error occurred while making API call for resources %v, skipping them for this iteration", targetResources(target))
+		return nil, err
+	}
+
+	var samples []configmetrics.Sample
+	if target.IsBatch() {
+		samples, err = configmetrics.DecodeBatch(data, target.ResourceIds)
+	} else {
+		samples, err = configmetrics.Decode(data, target.ResourceId)
+	}
+	if err != nil {
+		selfmetrics.DecodeErrors.Inc()
+		log.Logger.Error().Err(err).Msgf("error decoding response for resources %v", targetResources(target))
+		log.Logger.Info().Msgf("response: %q", data)
+		return nil, err
+	}
+
+	selfmetrics.SamplesParsed.Add(float64(len(samples)))
+	return samples, nil
 }
 
-func makeAPIRequest(config finopsdatatypes.ExporterScraperConfig, endpoint *httpcall.Endpoint) []byte {
-	res := &http.Response{StatusCode: 500}
-	err_call := fmt.Errorf("")
+// resourceDiscoverer keeps the resources found by the Resource Graph query, when enabled
+var resourceDiscoverer = resourcegraph.NewDiscoverer()
 
-	for ok := true; ok; ok = (err_call != nil || res.StatusCode != 200) {
-		httpClient, err := httpcall.HTTPClientForEndpoint(endpoint)
-		if err != nil {
-			log.Logger.Warn().Err(err).Msg("error while creating HTTP client")
-		}
+// metricDiscoverer caches the metric definitions of the resources, when the discovery is enabled
+var metricDiscoverer = discovery.NewDiscoverer()
 
-		res, err_call = httpcall.Do(context.TODO(), httpClient, httpcall.Options{
-			API:      &config.Spec.ExporterConfig.API,
-			Endpoint: endpoint,
-		})
+// scrapeResource scrapes a target or, with the metric discovery enabled, the targets
+// built from the metric definitions of its resources. It fails only if all of them fail.
+func scrapeResource(ctx context.Context, target configmetrics.Target, endpoint *httpcall.Endpoint, policy httpcall.RetryPolicy, opts configmetrics.Discovery) ([]configmetrics.Sample, error) {
+	if !opts.Enabled {
+		return scrapeTarget(ctx, target, endpoint, policy)
+	}
 
-		if err_call == nil && res.StatusCode != 200 {
-			log.Warn().Msgf("Received status code %d", res.StatusCode)
-			bodyData, _ := io.ReadAll(res.Body)
-			log.Warn().Msgf("Body %s", string(bodyData))
-		} else {
-			log.Logger.Warn().Err(err_call).Msg("error occurred while making API call")
-		}
-		log.Logger.Warn().Msgf("Retrying connection in 5s...")
-		time.Sleep(5 * time.Second)
-
-		log.Logger.Info().Msgf("Parsing Endpoint again...")
-		rc, _ := rest.InClusterConfig()
-		endpoint, err = endpoints.Resolve(context.Background(), endpoints.ResolveOptions{
-			RESTConfig: rc,
-			API:        &config.Spec.ExporterConfig.API,
-		})
+	targets, err := metricDiscoverer.Targets(ctx, opts, target, func(ctx context.Context, t configmetrics.Target) ([]byte, error) {
+		return makeAPIRequest(ctx, t, targetEndpoint(endpoint, t), policy)
+	})
+	if err != nil {
+		log.Logger.Error().Err(err).Msgf("error occurred while discovering the metrics of resources %v, skipping them for this iteration", targetResources(target))
+		return nil, err
+	}
+
+	samples := []configmetrics.Sample{}
+	var lastErr error
+	succeeded := 0
+	for _, t := range targets {
+		s, err := scrapeTarget(ctx, t, endpoint, policy)
 		if err != nil {
+			lastErr = err
 			continue
 		}
-		endpoint.ServerURL = utils.ReplaceVariables(endpoint.ServerURL, config.Spec.ExporterConfig.AdditionalVariables)
+		succeeded++
+		samples = append(samples, s...)
 	}
+	if succeeded == 0 {
+		return nil, lastErr
+	}
+	return samples, nil
+}
 
-	defer res.Body.Close()
+// targetResources returns the resources a target covers
+func targetResources(target configmetrics.Target) []string {
+	if target.IsBatch() {
+		return target.ResourceIds
+	}
+	return []string{target.ResourceId}
+}
 
-	data, err := io.ReadAll(res.Body)
-	if err != nil {
-		log.Logger.Warn().Err(err).Msg("an error has occured while reading response body")
+// scrapeTargets scrapes all the targets with at most maxConcurrency requests in flight,
+// until the context is done. It returns the samples and how many targets succeeded.
+func scrapeTargets(ctx context.Context, targets []configmetrics.Target, endpoint *httpcall.Endpoint, maxConcurrency int, policy httpcall.RetryPolicy, discoveryOpts configmetrics.Discovery) ([]configmetrics.Sample, int) {
+	type result struct {
+		samples []configmetrics.Sample
+		err     error
 	}
 
-	if res.StatusCode != 200 {
-		log.Warn().Msgf("Received status code %d", res.StatusCode)
-		log.Debug().Msgf("body: %s", data)
+	jobs := make(chan configmetrics.Target)
+	results := make(chan result)
+
+	var wg sync.WaitGroup
+	for i := 0; i < min(maxConcurrency, len(targets)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for target := range jobs {
+				samples, err := scrapeResource(ctx, target, endpoint, policy, discoveryOpts)
+				results <- result{samples: samples, err: err}
+			}
+		}()
 	}
 
-	return utils.TrapBOM(data)
-}
+	go func() {
+		defer func() {
+			close(jobs)
+			wg.Wait()
+			close(results)
+		}()
+		for _, target := range targets {
+			select {
+			case jobs <- target:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
 
-func getRecordsFromFile(byteData []byte, config finopsdatatypes.ExporterScraperConfig) [][]string {
-	data := configmetrics.Metrics{}
-	err := json.Unmarshal(byteData, &data)
-	if err != nil {
-		log.Logger.Error().Err(err).Msg("error decoding response")
-		if e, ok := err.(*json.SyntaxError); ok {
-			log.Logger.Error().Msgf("syntax error at byte offset %d", e.Offset)
+	samples := []configmetrics.Sample{}
+	succeeded := 0
+	for r := range results {
+		if r.err == nil {
+			succeeded++
 		}
-		log.Logger.Info().Msgf("response: %q", byteData)
-		log.Logger.Error().Err(err).Msg("error while reading file")
-		return nil
+		samples = append(samples, r.samples...)
 	}
+	return samples, succeeded
+}
+
+// sleep waits for the duration, returning false if the context is done before
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// remoteWriter pushes the samples to the remote write receiver, when configured
+var remoteWriter = remotewrite.NewWriter()
+
+// otlpExporter pushes the samples to the OpenTelemetry collector, when configured
+var otlpExporter = otlp.NewExporter()
+
+// tagCache keeps the tags of the resources, when their export is enabled
+var tagCache = tags.NewCache()
 
-	stringCSV := "ResourceId,metricName,timestamp,average,unit\n"
-	for _, value := range data.Value {
-		for _, timeseries := range value.Timeseries {
-			for _, metric := range timeseries.Data {
-				stringCSV += config.Spec.ExporterConfig.AdditionalVariables["ResourceId"] + "," + value.Name.Value + "," + metric.Timestamp.Format(time.RFC3339) + "," + metric.Average.AsDec().String() + "," + value.Unit + "\n"
+// withTags reads the tags of the scraped resources and, depending on the mode, adds them
+// to the labels of the samples or appends an azure_resource_info sample per resource.
+func withTags(ctx context.Context, config *configmetrics.Config, targets []configmetrics.Target, samples []configmetrics.Sample, endpoint *httpcall.Endpoint) []configmetrics.Sample {
+	resourceIds := []string{}
+	for _, target := range targets {
+		for _, id := range targetResources(target) {
+			if id != "" {
+				resourceIds = append(resourceIds, id)
 			}
 		}
 	}
 
-	stringCSV = strings.TrimSuffix(stringCSV, "\n")
+	api := config.Spec.ExporterConfig.API
+	labels := tagCache.Labels(ctx, config.Exporter.Tags, resourceIds, configmetrics.Target{API: api, Timeout: config.Exporter.RequestTimeout}, func(ctx context.Context, t configmetrics.Target) ([]byte, error) {
+		return makeAPIRequest(ctx, t, targetEndpoint(endpoint, t), config.Exporter.Retry)
+	}, config.Exporter.MaxConcurrency)
 
-	reader := csv.NewReader(strings.NewReader(stringCSV))
+	if config.Exporter.Tags.Mode == configmetrics.TagsModeLabels {
+		tags.AddLabels(samples, labels)
+		return samples
+	}
+	return append(samples, tags.InfoSamples(resourceIds, labels, time.Now())...)
+}
 
-	records, err := reader.ReadAll()
+// scrape resolves the endpoint, scrapes all the targets of the configuration and updates the collector
+func scrape(ctx context.Context, config *configmetrics.Config, metricsCollector *collector.Collector, checker *health.Checker) error {
+	// The endpoint Secret is served from memory, so that credential rotations are picked up at every poll
+	endpoint, err := resolveEndpoint(ctx, &config.Spec.ExporterConfig.API)
+	checker.EndpointResolved(err)
 	if err != nil {
-		log.Logger.Error().Err(err).Msg("error while reading file")
-		return nil
+		return fmt.Errorf("error while resolving endpoint: %w", err)
 	}
 
-	return records
+	targets := config.Targets()
+	if config.Exporter.ResourceGraph.Enabled {
+		api := config.Spec.ExporterConfig.API
+		resources, err := resourceDiscoverer.Resources(ctx, config.Exporter.ResourceGraph, configmetrics.Target{API: api, Timeout: config.Exporter.RequestTimeout}, func(ctx context.Context, t configmetrics.Target) ([]byte, error) {
+			return makeAPIRequest(ctx, t, targetEndpoint(endpoint, t), config.Exporter.Retry)
+		})
+		if err != nil {
+			return fmt.Errorf("error while discovering resources: %w", err)
+		}
+		targets = nil
+		if len(resources) > 0 {
+			targets = config.TargetsOf(resources)
+		}
+	}
+
+	samples, succeeded := scrapeTargets(ctx, targets, endpoint, config.Exporter.MaxConcurrency, config.Exporter.Retry, config.Exporter.Discovery)
+	if ctx.Err() != nil {
+		// The scrape has been interrupted, its results are partial
+		return ctx.Err()
+	}
+	if succeeded > 0 {
+		selfmetrics.LastSuccessfulScrapeTimestamp.SetToCurrentTime()
+		checker.ScrapeSucceeded()
+	}
+	if config.Exporter.Tags.Enabled {
+		samples = withTags(ctx, config, targets, samples, endpoint)
+	}
+	log.Info().Msgf("Analyzing %d samples...", len(samples))
+
+	metricsCollector.Update(samples, collector.Options{
+		FullWindow:             config.Exporter.FullWindow,
+		StaleSeriesGracePeriod: config.Exporter.StaleSeriesGracePeriod,
+	})
+	selfmetrics.ExportedSeries.Set(float64(metricsCollector.Len()))
+	log.Info().Msgf("Exporting %d series", metricsCollector.Len())
+
+	if config.Exporter.RemoteWrite.URL != "" {
+		if err := remoteWriter.Write(ctx, config.Exporter.RemoteWrite, samples, config.Exporter.StaleSeriesGracePeriod); err != nil {
+			log.Logger.Error().Err(err).Msg("error while sending samples to the remote write receiver")
+		}
+	}
+	if config.Exporter.OTLP.Endpoint != "" {
+		if err := otlpExporter.Export(ctx, config.Exporter.OTLP, samples, config.Exporter.StaleSeriesGracePeriod); err != nil {
+			log.Logger.Error().Err(err).Msg("error while exporting samples through OTLP")
+		}
+	}
+	return nil
 }
 
-func updatedMetrics(registry *prometheus.Registry, prometheusMetrics map[string]recordGaugeCombo) {
-	for {
-		config, endpoint, err := ParseConfigFile("/config/config.yaml")
-		if err != nil {
-			log.Logger.Error().Err(err).Msg("error while parsing configuration, trying again in 5s...")
-			time.Sleep(5 * time.Second)
+func updatedMetrics(ctx context.Context, manager *configmetrics.Manager, metricsCollector *collector.Collector, checker *health.Checker) {
+	for ctx.Err() == nil {
+		config := manager.Current()
+		if config == nil {
+			if err := manager.Load(); err != nil {
+				checker.ConfigFailed(err)
+				log.Logger.Error().Err(err).Msg("error while parsing configuration, trying again in 5s...")
+				sleep(ctx, 5*time.Second)
+			}
 			continue
 		}
-		data := makeAPIRequest(config, endpoint)
-		records := getRecordsFromFile(data, config)
 
-		notFound := true
-		log.Info().Msgf("Analyzing %d records...", len(records))
-		for i, record := range records {
-			// Skip header line
-			if i == 0 {
+		// In pull mode Azure is called when Prometheus scrapes /metrics,
+		// the loop only follows the configuration changes
+		if config.Exporter.Mode == configmetrics.ModePull {
+			checker.ConfigLoaded(config.Spec.ExporterConfig.PollingInterval.Duration, 0)
+			metricsCollector.OnDemand(func(ctx context.Context) error {
+				return scrape(ctx, manager.Current(), metricsCollector, checker)
+			}, config.Exporter.MinScrapeInterval)
+
+			// The endpoint is resolved here too, so that the exporter becomes ready before the first scrape
+			_, err := resolveEndpoint(ctx, &config.Spec.ExporterConfig.API)
+			checker.EndpointResolved(err)
+			if err != nil {
+				log.Logger.Error().Err(err).Msg("error while resolving endpoint, trying again in 5s...")
+				sleep(ctx, 5*time.Second)
 				continue
 			}
+			sleep(ctx, config.Spec.ExporterConfig.PollingInterval.Duration)
+			continue
+		}
+		metricsCollector.OnDemand(nil, 0)
+		checker.ConfigLoaded(config.Spec.ExporterConfig.PollingInterval.Duration, config.Exporter.ReadinessIntervals)
 
-			notFound = true
-			if _, ok := prometheusMetrics[strings.Join(record, " ")]; ok {
-				metricValue, err := strconv.ParseFloat(record[3], 64)
-				if err != nil {
-					log.Logger.Warn().Err(err).Msgf("skipping this record for this iteration, error while parsing metric value: %s", record[3])
-					continue
-				}
-				prometheusMetrics[strings.Join(record, " ")].gauge.Set(metricValue)
-				notFound = false
-			}
-
-			if notFound {
-				labels := prometheus.Labels{}
-				for j, value := range record {
-					labels[records[0][j]] = value
-				}
-				newMetricsRow := promauto.NewGauge(prometheus.GaugeOpts{
-					Name:        strings.ReplaceAll(strings.ToLower(labels[records[0][1]]), " ", "_"),
-					ConstLabels: labels,
-				})
-				metricValue, err := strconv.ParseFloat(records[i][3], 64)
-				if err != nil {
-					log.Logger.Warn().Err(err).Msgf("skipping this record for this iteration, error while parsing metric value: %s", records[i][3])
-					continue
-				}
-
-				newMetricsRow.Set(metricValue)
-				prometheusMetrics[strings.Join(record, " ")] = recordGaugeCombo{record: record, gauge: newMetricsRow}
-				registry.MustRegister(newMetricsRow)
+		if err := scrape(ctx, config, metricsCollector, checker); err != nil {
+			if ctx.Err() != nil {
+				break
 			}
+			log.Logger.Error().Err(err).Msg("error while scraping, trying again in 5s...")
+			sleep(ctx, 5*time.Second)
+			continue
 		}
-		time.Sleep(config.Spec.ExporterConfig.PollingInterval.Duration)
+
+		sleep(ctx, config.Spec.ExporterConfig.PollingInterval.Duration)
 	}
+	log.Logger.Info().Msg("Scrape loop stopped")
+}
+
+// onDemand refreshes the collector, in pull mode, before serving the metrics. The scrape is
+// bounded by the Prometheus scrape timeout, or by the minimum scrape interval when not sent,
+// keeping a share of it to serve the response, and it is interrupted on shutdown.
+func onDemand(ctx context.Context, manager *configmetrics.Manager, metricsCollector *collector.Collector, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout := configmetrics.DefaultMinScrapeInterval
+		if config := manager.Current(); config != nil {
+			timeout = config.Exporter.MinScrapeInterval
+		}
+		if seconds, err := strconv.ParseFloat(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64); err == nil && seconds > 0 {
+			timeout = time.Duration(seconds * float64(time.Second))
+		}
+
+		scrapeCtx, cancel := context.WithTimeout(r.Context(), timeout*9/10)
+		defer cancel()
+		defer context.AfterFunc(ctx, cancel)()
+
+		metricsCollector.Refresh(scrapeCtx)
+		next.ServeHTTP(w, r)
+	})
 }
 
 func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	registry := prometheus.NewRegistry()
-	go updatedMetrics(registry, map[string]recordGaugeCombo{})
+	selfmetrics.Register(registry)
+	httpcall.SetObserver(&httpcall.Observer{
+		Request: func(code string, duration time.Duration) {
+			selfmetrics.AzureRequests.WithLabelValues(code).Inc()
+			selfmetrics.AzureRequestDuration.WithLabelValues(code).Observe(duration.Seconds())
+		},
+		TokenRefresh: func(authType string, err error) {
+			result := "success"
+			if err != nil {
+				result = "failure"
+			}
+			selfmetrics.TokenRefreshes.WithLabelValues(authType, result).Inc()
+		},
+	})
 
-	handler := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	if rc, err := rest.InClusterConfig(); err == nil {
+		if cs, err := kubernetes.NewForConfig(rc); err == nil {
+			cache := secrets.NewCache(cs)
+			defer cache.Stop()
+			endpointSecrets = cache
+		}
+	}
+
+	manager := configmetrics.NewManager(configFile)
+	if err := manager.Load(); err != nil {
+		log.Logger.Error().Err(err).Msg("error while parsing configuration")
+	}
+	go func() {
+		if err := manager.Watch(ctx); err != nil {
+			log.Logger.Error().Err(err).Msg("unable to watch the configuration, changes will not be reloaded")
+		}
+	}()
+
+	metricsCollector := collector.New()
+	checker := health.NewChecker()
+	scrapeDone := make(chan struct{})
+	go func() {
+		defer close(scrapeDone)
+		updatedMetrics(ctx, manager, metricsCollector, checker)
+	}()
+
+	handler := onDemand(ctx, manager, metricsCollector, promhttp.HandlerFor(metricsCollector.Gatherer(registry), promhttp.HandlerOpts{}))
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handler)
+	mux.Handle("/healthz", health.LivenessHandler())
+	mux.Handle("/readyz", checker.ReadinessHandler())
+	server := &http.Server{Addr: ":2112", Handler: mux}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Logger.Error().Err(err).Msg("error while serving metrics")
+			stop()
+		}
+	}()
+
+	<-ctx.Done()
+	log.Logger.Info().Msg("Shutting down...")
 
-	http.Handle("/metrics", handler)
-	http.ListenAndServe(":2112", nil)
+	shutdownTimeout := configmetrics.DefaultShutdownTimeout
+	if config := manager.Current(); config != nil {
+		shutdownTimeout = config.Exporter.ShutdownTimeout
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Logger.Warn().Err(err).Msg("error while shutting down the HTTP server")
+	}
+
+	select {
+	case <-scrapeDone:
+	case <-shutdownCtx.Done():
+		log.Logger.Warn().Msg("timed out waiting for the scrape loop to stop")
+	}
+
+	if err := otlpExporter.Shutdown(shutdownCtx); err != nil {
+		log.Logger.Warn().Err(err).Msg("error while shutting down the OTLP exporter")
+	}
 }